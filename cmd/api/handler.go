@@ -0,0 +1,217 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/isqad/kexpress/internal/logger"
+	"github.com/isqad/kexpress/internal/service"
+)
+
+// handler is HTTP handler returning response body or error.
+// Body is encoded as JSON, errors are responded with JSON error envelope.
+type handler func(r *http.Request) (interface{}, error)
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v, err := h(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// apiError is error responded to client with the status
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func unavailable(format string, args ...interface{}) error {
+	return &apiError{Status: http.StatusServiceUnavailable, Message: fmt.Sprintf(format, args...)}
+}
+
+// errorResponse is JSON error envelope
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Status    int    `json:"status"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// writeError responds with status of the error.
+// Unknown errors are logged and responded as internal error without details.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	reqID := middleware.GetReqID(r.Context())
+
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.Is(err, sql.ErrNoRows):
+		apiErr = &apiError{Status: http.StatusNotFound, Message: http.StatusText(http.StatusNotFound)}
+	case errors.Is(err, service.ErrInvalidCursor):
+		apiErr = &apiError{Status: http.StatusBadRequest, Message: err.Error()}
+	default:
+		logger.FromContext(r.Context()).With("error", err).Errorf("request %s %s failed", r.Method, r.URL.RequestURI())
+		apiErr = &apiError{Status: http.StatusInternalServerError, Message: http.StatusText(http.StatusInternalServerError)}
+	}
+
+	writeJSON(w, apiErr.Status, &errorResponse{Error: errorBody{
+		Status:    apiErr.Status,
+		Message:   apiErr.Message,
+		RequestID: reqID,
+	}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Default().With("error", err).Errorf("write response failed")
+	}
+}
+
+// requestLogger puts logger with request ID into request context and logs
+// every request after it is served
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := logger.Default().With("request_id", middleware.GetReqID(r.Context()))
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		next.ServeHTTP(ww, r.WithContext(logger.NewContext(r.Context(), l)))
+
+		l.With("status", ww.Status(), "bytes", ww.BytesWritten(), "duration_ms", time.Since(start).Milliseconds()).
+			Infof("%s %s", r.Method, r.URL.RequestURI())
+	})
+}
+
+// recoverer responds with internal error to requests panicked in handlers
+func recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				logger.FromContext(r.Context()).With("stack", string(debug.Stack())).Errorf("panic: %v", rec)
+				writeError(w, r, fmt.Errorf("panic: %v", rec))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notFoundHandler responds to unknown API routes
+func notFoundHandler(r *http.Request) (interface{}, error) {
+	return nil, notFound("%s not found", r.URL.Path)
+}
+
+// queryPeriod parses period in days, 30 if not given
+func queryPeriod(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("period")
+	if v == "" {
+		return 30, nil
+	}
+
+	period, err := strconv.Atoi(v)
+	if err == nil {
+		for _, p := range service.CategoryPeriods {
+			if p == period {
+				return period, nil
+			}
+		}
+	}
+	return 0, badRequest("invalid period, must be one of %v", service.CategoryPeriods)
+}
+
+// querySort parses sort key, descending if prefixed with minus.
+// Sorts by the default key descending if not given.
+func querySort(r *http.Request, def string, valid func(string) bool) (string, bool, error) {
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		return def, true, nil
+	}
+
+	desc := strings.HasPrefix(sort, "-")
+	sort = strings.TrimPrefix(sort, "-")
+	if !valid(sort) {
+		return "", false, badRequest("invalid sort %s", sort)
+	}
+	return sort, desc, nil
+}
+
+// queryInt64 parses optional integer parameter, zero if not given
+func queryInt64(r *http.Request, name string) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, badRequest("invalid %s", name)
+	}
+	return n, nil
+}
+
+// queryFloat parses optional number parameter, zero if not given
+func queryFloat(r *http.Request, name string) (float64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, badRequest("invalid %s", name)
+	}
+	return f, nil
+}
+
+// queryLimit parses page size, def if not given
+func queryLimit(r *http.Request, def int, max int) (int, error) {
+	limit, err := queryInt64(r, "limit")
+	if err != nil {
+		return 0, err
+	}
+	if limit == 0 {
+		return def, nil
+	}
+	if limit < 0 || limit > int64(max) {
+		return 0, badRequest("invalid limit, must be between 1 and %d", max)
+	}
+	return int(limit), nil
+}
+
+// urlParamID parses ID from the route
+func urlParamID(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, name), 10, 64)
+	if err != nil {
+		return 0, badRequest("invalid %s", name)
+	}
+	return id, nil
+}
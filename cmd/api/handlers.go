@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/isqad/kexpress/internal/service"
+	"github.com/jmoiron/sqlx"
+)
+
+// api serves JSON API
+type api struct {
+	db *sqlx.DB
+}
+
+func (a *api) roots(r *http.Request) (interface{}, error) {
+	return service.RootCategories(r.Context(), a.db)
+}
+
+func (a *api) categories(r *http.Request) (interface{}, error) {
+	rootID, err := queryInt64(r, "root_id")
+	if err != nil {
+		return nil, err
+	}
+	if rootID == 0 {
+		return nil, badRequest("root_id is required")
+	}
+	period, err := queryPeriod(r)
+	if err != nil {
+		return nil, err
+	}
+	sort, desc, err := querySort(r, "proceeds", service.IsCategoryStatsSort)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.CategoryLeavesStats(r.Context(), a.db, rootID, period, sort, desc)
+}
+
+func (a *api) sellers(r *http.Request) (interface{}, error) {
+	opts := service.SellerListOptions{}
+
+	var err error
+	if opts.Period, err = queryPeriod(r); err != nil {
+		return nil, err
+	}
+	if opts.Sort, opts.Desc, err = querySort(r, "revenue", service.IsSellerStatsSort); err != nil {
+		return nil, err
+	}
+	if opts.RootCategoryID, err = queryInt64(r, "root_id"); err != nil {
+		return nil, err
+	}
+	if opts.Limit, err = queryLimit(r, 50, 500); err != nil {
+		return nil, err
+	}
+	offset, err := queryInt64(r, "offset")
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, badRequest("invalid offset")
+	}
+	opts.Offset = int(offset)
+
+	return service.Sellers(r.Context(), a.db, opts)
+}
+
+func (a *api) seller(r *http.Request) (interface{}, error) {
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	period, err := queryPeriod(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := service.FindSellerDetails(r.Context(), a.db, id, period)
+	if err != nil {
+		return nil, err
+	}
+	d.ProductsURL = fmt.Sprintf("/api/v1/products?seller_id=%d", id)
+
+	return d, nil
+}
+
+func (a *api) products(r *http.Request) (interface{}, error) {
+	f := service.ProductFilter{
+		Cursor:  r.URL.Query().Get("cursor"),
+		InStock: r.URL.Query().Get("in_stock") == "true",
+	}
+
+	var err error
+	if f.Sort, f.Desc, err = querySort(r, "orders", service.IsProductSort); err != nil {
+		return nil, err
+	}
+	if f.CategoryID, err = queryInt64(r, "category_id"); err != nil {
+		return nil, err
+	}
+	if f.SellerID, err = queryInt64(r, "seller_id"); err != nil {
+		return nil, err
+	}
+	if f.MinPrice, err = queryFloat(r, "min_price"); err != nil {
+		return nil, err
+	}
+	if f.MaxPrice, err = queryFloat(r, "max_price"); err != nil {
+		return nil, err
+	}
+	if f.MinRating, err = queryFloat(r, "min_rating"); err != nil {
+		return nil, err
+	}
+	minOrders, err := queryInt64(r, "min_orders")
+	if err != nil {
+		return nil, err
+	}
+	f.MinOrders = int(minOrders)
+	if f.Limit, err = queryLimit(r, 50, 500); err != nil {
+		return nil, err
+	}
+
+	return service.Products(r.Context(), a.db, f)
+}
+
+func (a *api) product(r *http.Request) (interface{}, error) {
+	id, err := urlParamID(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	period, err := queryPeriod(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.FindProductDetails(r.Context(), a.db, id, period)
+}
+
+func (a *api) search(r *http.Request) (interface{}, error) {
+	opts := service.SearchOptions{Query: strings.TrimSpace(r.URL.Query().Get("q"))}
+	if opts.Query == "" {
+		return nil, badRequest("q is required")
+	}
+
+	var err error
+	if opts.CategoryID, err = queryInt64(r, "category_id"); err != nil {
+		return nil, err
+	}
+	if opts.Limit, err = queryLimit(r, 50, 500); err != nil {
+		return nil, err
+	}
+	offset, err := queryInt64(r, "offset")
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, badRequest("invalid offset")
+	}
+	opts.Offset = int(offset)
+
+	return service.SearchProducts(r.Context(), a.db, opts)
+}
+
+func (a *api) facets(r *http.Request) (interface{}, error) {
+	opts := service.CharFacetOptions{Char: r.URL.Query().Get("char")}
+
+	var err error
+	if opts.CategoryID, err = queryInt64(r, "category_id"); err != nil {
+		return nil, err
+	}
+	if opts.CategoryID == 0 {
+		return nil, badRequest("category_id is required")
+	}
+	if opts.Period, err = queryPeriod(r); err != nil {
+		return nil, err
+	}
+	// values are always ranked from the best
+	if opts.Sort, _, err = querySort(r, "products", service.IsCharFacetSort); err != nil {
+		return nil, err
+	}
+	limit, err := queryInt64(r, "limit")
+	if err != nil {
+		return nil, err
+	}
+	if limit < 0 {
+		return nil, badRequest("invalid limit")
+	}
+	opts.Limit = int(limit)
+
+	return service.CharFacets(r.Context(), a.db, opts)
+}
+
+func (a *api) crawlRuns(r *http.Request) (interface{}, error) {
+	limit, err := queryLimit(r, 10, 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.LastCrawlRuns(r.Context(), a.db, r.URL.Query().Get("job"), limit)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/isqad/kexpress/db"
+)
+
+type readiness struct {
+	Status        string `json:"status"`
+	SchemaVersion uint   `json:"schemaVersion"`
+}
+
+// healthz responds while the process serves requests
+func healthz(r *http.Request) (interface{}, error) {
+	return map[string]string{"status": "ok"}, nil
+}
+
+// readyz responds with unavailable status if database is not ready, see db.CheckReady
+func (a *api) readyz(r *http.Request) (interface{}, error) {
+	version, err := db.CheckReady(r.Context(), a.db)
+	if err != nil {
+		return nil, unavailable("%v", err)
+	}
+
+	return &readiness{Status: "ok", SchemaVersion: version}, nil
+}
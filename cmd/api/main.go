@@ -1,26 +1,32 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
-	"strconv"
+	"syscall"
 	"text/template"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/isqad/kexpress/internal/service"
+	"github.com/isqad/kexpress/db"
+	"github.com/isqad/kexpress/internal/logger"
 	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli/v2"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
+// shutdownTimeout is time given to in-flight requests on shutdown
+const shutdownTimeout = 15 * time.Second
+
 func main() {
 	app := &cli.App{
 		Name: "kexpress-api",
@@ -30,13 +36,19 @@ func main() {
 			&cli.StringFlag{Name: "postgres-db", Aliases: []string{"d"}, Value: "kexpress"},
 			&cli.StringFlag{Name: "postgres-host", Aliases: []string{"c"}, Value: "localhost"},
 			&cli.StringFlag{Name: "postgres-port", Aliases: []string{"p"}, Value: "15432"},
+			&cli.StringFlag{Name: "log-level", Value: "info", EnvVars: []string{"KEXPRESS_LOG_LEVEL"}, Usage: "debug, info, warn or error"},
+			&cli.BoolFlag{Name: "check-schema", EnvVars: []string{"KEXPRESS_CHECK_SCHEMA"}, Usage: "refuse to start if database schema is dirty or behind the binary"},
+		},
+		Before: func(ctx *cli.Context) error {
+			return logger.Configure(ctx.String("log-level"))
 		},
 		Action: startServer,
 	}
 
 	err := app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
+		logger.Default().With("error", err).Errorf("exit")
+		os.Exit(1)
 	}
 }
 
@@ -49,47 +61,45 @@ func startServer(ctx *cli.Context) error {
 		ctx.String("postgres-port"),
 		ctx.String("postgres-db"),
 	)
-	db, err := sqlx.Connect("pgx", dataSrcName)
+	conn, err := sqlx.Connect("pgx", dataSrcName)
 	if err != nil {
 		return err
 	}
-	if err = db.Ping(); err != nil {
+	// database is closed after in-flight requests are drained, see below
+	defer conn.Close()
+	if err = conn.Ping(); err != nil {
 		return err
 	}
-
-	r := chi.NewRouter()
-	r.Use(middleware.Logger)
-	r.Get("/api/v1/roots", func(w http.ResponseWriter, r *http.Request) {
-		rubrics, err := service.RootCategories(db)
-		if err != nil {
-			log.Fatal(err)
+	if ctx.Bool("check-schema") {
+		if err = db.CheckSchema(ctx.Context, conn); err != nil {
+			return err
 		}
+	}
 
-		response, err := json.Marshal(rubrics)
-		if err != nil {
-			log.Fatal(err)
-		}
-		w.Write(response)
-	})
-	r.Get("/api/v1/categories", func(w http.ResponseWriter, r *http.Request) {
-		params, ok := r.URL.Query()["root_id"]
-		if !ok || len(params[0]) < 1 {
-			log.Fatal(errors.New("No root_id"))
-		}
-		rootID, err := strconv.ParseInt(params[0], 10, 64)
-		if err != nil {
-			log.Fatal(errors.New("No root_id"))
-		}
+	prometheus.MustRegister(collectors.NewDBStatsCollector(conn.DB, ctx.String("postgres-db")))
 
-		rubrics, err := service.CategoryLeaves(db, rootID)
-		if err != nil {
-			log.Fatal(err)
-		}
-		response, err := json.Marshal(rubrics)
-		if err != nil {
-			log.Fatal(err)
-		}
-		w.Write(response)
+	a := &api{db: conn}
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(requestLogger)
+	// instrument wraps recoverer, so panicked requests are counted as 500
+	r.Use(instrument)
+	r.Use(recoverer)
+	r.Method("GET", "/metrics", promhttp.Handler())
+	r.Method("GET", "/healthz", handler(healthz))
+	r.Method("GET", "/readyz", handler(a.readyz))
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Method("GET", "/roots", handler(a.roots))
+		r.Method("GET", "/categories", handler(a.categories))
+		r.Method("GET", "/sellers", handler(a.sellers))
+		r.Method("GET", "/sellers/{id}", handler(a.seller))
+		r.Method("GET", "/products", handler(a.products))
+		r.Method("GET", "/products/{id}", handler(a.product))
+		r.Method("GET", "/search", handler(a.search))
+		r.Method("GET", "/facets", handler(a.facets))
+		r.Method("GET", "/crawl_runs", handler(a.crawlRuns))
+		r.NotFound(handler(notFoundHandler).ServeHTTP)
 	})
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		tmpl, err := template.New("app").ParseFiles(
@@ -97,10 +107,14 @@ func startServer(ctx *cli.Context) error {
 			"web/templates/index.html",
 		)
 		if err != nil {
-			log.Fatal(err)
+			logger.FromContext(r.Context()).With("error", err).Errorf("parse templates failed")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
 		}
 
-		tmpl.ExecuteTemplate(w, "layout.html", nil)
+		if err := tmpl.ExecuteTemplate(w, "layout.html", nil); err != nil {
+			logger.FromContext(r.Context()).With("error", err).Errorf("render template failed")
+		}
 	})
 	// Serve static assets
 	// serves files from web/static dir
@@ -120,8 +134,26 @@ func startServer(ctx *cli.Context) error {
 		WriteTimeout:      10 * time.Second,
 	}
 	// Start HTTP server
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	sigCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-serverErr:
 		return err
+	case <-sigCtx.Done():
+	}
+
+	// drain in-flight requests before closing database
+	logger.Default().Infof("shutting down HTTP server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Default().With("error", err).Errorf("shutdown failed")
 	}
 
 	return nil
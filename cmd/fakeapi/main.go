@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/isqad/kexpress/internal/testserver"
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	defaults := testserver.DefaultOptions
+
+	app := &cli.App{
+		Name:  "kexpress-fakeapi",
+		Usage: "fake KazanExpress API serving generated catalog",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "addr", Value: ":3001"},
+			&cli.Int64Flag{Name: "seed", Value: defaults.Seed},
+			&cli.IntFlag{Name: "roots", Value: defaults.Roots, Usage: "number of root categories"},
+			&cli.IntFlag{Name: "children", Value: defaults.Children, Usage: "number of children of every category"},
+			&cli.IntFlag{Name: "depth", Value: defaults.Depth, Usage: "number of category levels below roots"},
+			&cli.IntFlag{Name: "products", Value: defaults.Products, Usage: "number of products in every leaf category"},
+			&cli.IntFlag{Name: "sellers", Value: defaults.Sellers, Usage: "number of sellers"},
+			&cli.DurationFlag{Name: "latency", Usage: "latency of every response"},
+			&cli.Float64Flag{Name: "error-rate", Usage: "share of 500 responses"},
+			&cli.Float64Flag{Name: "rate-limit-rate", Usage: "share of 429 responses"},
+			&cli.Float64Flag{Name: "not-found-rate", Usage: "share of product cards not found"},
+			&cli.Float64Flag{Name: "duplicate-rate", Usage: "share of listing items duplicated from the previous page"},
+			&cli.IntFlag{Name: "amount-skew", Usage: "added to products amount of categories"},
+			&cli.DurationFlag{Name: "tick", Usage: "period of orders and stock changes, 0 for static catalog"},
+		},
+		Action: startServer,
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func startServer(ctx *cli.Context) error {
+	srv := testserver.New(testserver.Options{
+		Seed:          ctx.Int64("seed"),
+		Roots:         ctx.Int("roots"),
+		Children:      ctx.Int("children"),
+		Depth:         ctx.Int("depth"),
+		Products:      ctx.Int("products"),
+		Sellers:       ctx.Int("sellers"),
+		Latency:       ctx.Duration("latency"),
+		ErrorRate:     ctx.Float64("error-rate"),
+		RateLimitRate: ctx.Float64("rate-limit-rate"),
+		NotFoundRate:  ctx.Float64("not-found-rate"),
+		DuplicateRate: ctx.Float64("duplicate-rate"),
+		AmountSkew:    ctx.Int("amount-skew"),
+		Tick:          ctx.Duration("tick"),
+	})
+
+	r := chi.NewRouter()
+	r.Use(middleware.Logger)
+	r.Mount("/", srv.Handler())
+
+	server := &http.Server{
+		Addr:              ctx.String("addr"),
+		Handler:           r,
+		ReadHeaderTimeout: 1 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+	log.Printf("Fake API listening on %s\n", server.Addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/isqad/kexpress/internal/schedule"
+	"github.com/isqad/kexpress/internal/service"
+	"github.com/jmoiron/sqlx"
+	"github.com/robfig/cron/v3"
+	"github.com/urfave/cli/v2"
+)
+
+var crawlFlags = []cli.Flag{
+	&cli.Int64Flag{Name: "root", Usage: "root category ID", Required: true},
+	&cli.Int64SliceFlag{Name: "category", Usage: "crawl only the given leaf categories of the root"},
+	&cli.IntFlag{Name: "concurrency", Usage: "number of categories crawled concurrently"},
+	&cli.StringFlag{Name: "job", Value: "manual", Usage: "job name the crawl is recorded under, products stage parses the last listing of the job and fails without one"},
+	&cli.BoolFlag{Name: "resume", Usage: "continue the last unfinished session of the job"},
+	&cli.Int64Flag{Name: "session", Usage: "continue the given session"},
+}
+
+var crawlCommand = &cli.Command{
+	Name:  "crawl",
+	Usage: "run crawl stage once",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "categories",
+			Usage: "crawl category tree",
+			Action: withCrawler(func(runCtx context.Context, ctx *cli.Context, db *sqlx.DB, client service.MarketplaceClient) error {
+				return service.CrawlCategories(runCtx, db, client)
+			}),
+		},
+		{
+			Name:  "list",
+			Usage: "crawl product listings of the root category",
+			Flags: crawlFlags,
+			Action: withCrawler(func(runCtx context.Context, ctx *cli.Context, db *sqlx.DB, client service.MarketplaceClient) error {
+				return service.CrawlProductList(runCtx, db, client, ctx.Int64("root"), crawlOptions(ctx))
+			}),
+		},
+		{
+			Name:  "products",
+			Usage: "crawl not parsed product cards of the root category",
+			Flags: crawlFlags,
+			Action: withCrawler(func(runCtx context.Context, ctx *cli.Context, db *sqlx.DB, client service.MarketplaceClient) error {
+				return service.CrawlProducts(runCtx, db, client, ctx.Int64("root"), crawlOptions(ctx))
+			}),
+		},
+		{
+			Name:  "product",
+			Usage: "fetch and print one product card",
+			Flags: []cli.Flag{
+				&cli.Int64Flag{Name: "portal-id", Usage: "product ID on the marketplace", Required: true},
+				&cli.BoolFlag{Name: "save", Usage: "save the product"},
+			},
+			Action: func(ctx *cli.Context) error {
+				if ctx.Bool("save") {
+					return withCrawler(func(runCtx context.Context, ctx *cli.Context, db *sqlx.DB, client service.MarketplaceClient) error {
+						p, err := service.CrawlProduct(runCtx, db, client, ctx.Int64("portal-id"))
+						if err != nil {
+							return err
+						}
+						return printJSON(p)
+					})(ctx)
+				}
+
+				client, err := newClient(ctx)
+				if err != nil {
+					return err
+				}
+				runCtx, stop := signalContext(ctx)
+				defer stop()
+
+				p, err := client.FetchProduct(runCtx, ctx.Int64("portal-id"))
+				if err != nil {
+					return err
+				}
+				return printJSON(p)
+			},
+		},
+	},
+}
+
+var estimateCommand = &cli.Command{
+	Name:  "estimate",
+	Usage: "estimate daily sales of the root category products",
+	Flags: []cli.Flag{
+		&cli.Int64Flag{Name: "root", Usage: "root category ID", Required: true},
+		&cli.IntFlag{Name: "days", Value: service.SalesWindow, Usage: "number of last days to estimate"},
+	},
+	Action: func(ctx *cli.Context) error {
+		db, err := connectDB(ctx)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		runCtx, stop := signalContext(ctx)
+		defer stop()
+
+		now := time.Now()
+		return service.EstimateCategorySales(runCtx, db, ctx.Int64("root"), now.AddDate(0, 0, -ctx.Int("days")), now)
+	},
+}
+
+var statusCommand = &cli.Command{
+	Name:  "status",
+	Usage: "print scheduled jobs and their last runs",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "job", Usage: "print runs of the job only"},
+		&cli.IntFlag{Name: "limit", Value: 3, Usage: "number of runs per job"},
+	},
+	Action: func(ctx *cli.Context) error {
+		db, err := connectDB(ctx)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+		cfg, err := schedule.Load(ctx.String("config"))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "JOB\tSCHEDULE\tNEXT\tROOTS\tSTAGES")
+		for _, job := range cfg.Jobs {
+			s, err := cron.ParseStandard(job.Schedule)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%v\n",
+				job.Name, job.Schedule, s.Next(time.Now()).Format(time.RFC3339), job.Roots, job.Stages)
+		}
+		fmt.Fprintln(w)
+
+		runs, err := service.LastCrawlRuns(ctx.Context, db, ctx.String("job"), ctx.Int("limit"))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "JOB\tROOT\tSTAGE\tSTATUS\tSTARTED\tDURATION\tPAGES\tDISCOVERED\tPARSED\tUNCHANGED\tFAILED")
+		for _, r := range runs {
+			duration := "-"
+			if r.FinishedAt != nil {
+				duration = r.FinishedAt.Sub(r.StartedAt).Round(time.Second).String()
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\n",
+				r.Job, r.RootCategoryID, r.Stage, r.Status, r.StartedAt.Format(time.RFC3339), duration,
+				r.PagesFetched, r.ProductsDiscovered, r.ProductsParsed, r.ProductsUnchanged,
+				r.PagesFailed+r.ProductsFailed)
+		}
+
+		return w.Flush()
+	},
+}
+
+// withCrawler connects to database and creates marketplace client for the action.
+// Action context is canceled on interrupt, so the crawl stops leaving its checkpoints.
+func withCrawler(action func(context.Context, *cli.Context, *sqlx.DB, service.MarketplaceClient) error) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		db, err := connectDB(ctx)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		runCtx, stop := signalContext(ctx)
+		defer stop()
+
+		return action(runCtx, ctx, db, client)
+	}
+}
+
+// signalContext returns context of the command canceled on interrupt or SIGTERM
+func signalContext(ctx *cli.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+}
+
+func crawlOptions(ctx *cli.Context) service.CrawlOptions {
+	return service.CrawlOptions{
+		Job:        ctx.String("job"),
+		Workers:    ctx.Int("concurrency"),
+		Categories: ctx.Int64Slice("category"),
+		SessionID:  ctx.Int64("session"),
+		Resume:     ctx.Bool("resume"),
+	}
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"math/rand"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/isqad/kexpress/db"
+	"github.com/isqad/kexpress/internal/logger"
 	"github.com/isqad/kexpress/internal/service"
 	"github.com/jmoiron/sqlx"
-	"github.com/robfig/cron/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/urfave/cli/v2"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
@@ -24,21 +28,45 @@ func main() {
 		Name: "kexpress",
 		Flags: []cli.Flag{
 			&cli.StringFlag{Name: "postgres-user", Aliases: []string{"u"}, Value: "postgres"},
-			&cli.StringFlag{Name: "postgres-password", Aliases: []string{"P"}, Required: true},
+			&cli.StringFlag{Name: "postgres-password", Aliases: []string{"P"}, Usage: "required by commands using database"},
 			&cli.StringFlag{Name: "postgres-db", Aliases: []string{"d"}, Value: "kexpress"},
 			&cli.StringFlag{Name: "postgres-host", Aliases: []string{"c"}, Value: "localhost"},
 			&cli.StringFlag{Name: "postgres-port", Aliases: []string{"p"}, Value: "15432"},
+			&cli.StringFlag{Name: "config", Value: "config/schedule.yml", Usage: "crawl jobs config"},
+			&cli.StringFlag{Name: "log-level", Value: "info", EnvVars: []string{"KEXPRESS_LOG_LEVEL"}, Usage: "debug, info, warn or error"},
+			&cli.BoolFlag{Name: "check-schema", EnvVars: []string{"KEXPRESS_CHECK_SCHEMA"}, Usage: "refuse to start if database schema is dirty or behind the binary"},
+			&cli.StringFlag{Name: "api-url", Value: service.DefaultBaseURL, EnvVars: []string{"KEXPRESS_API_URL"}},
+			&cli.Float64Flag{Name: "rps", Value: 5, Usage: "max upstream requests per second, 0 disables the limit"},
+			&cli.IntFlag{Name: "max-inflight", Value: 10, Usage: "max concurrent upstream requests, 0 disables the limit"},
+			&cli.IntFlag{Name: "max-attempts", Value: service.DefaultRetryPolicy.MaxAttempts, Usage: "max attempts of failed upstream request"},
+			&cli.StringFlag{Name: "archive-mode", Value: "off", Usage: "off, record or replay upstream responses"},
+			&cli.StringFlag{Name: "archive-dir", Value: "db/archive", Usage: "directory of the responses archive"},
+			&cli.StringFlag{Name: "status-addr", Value: ":3002", Usage: "address of the status and metrics server, empty disables it"},
+			&cli.TimestampFlag{Name: "replay-at", Layout: time.RFC3339, Usage: "replay responses fetched not after the given time"},
+		},
+		Before: func(ctx *cli.Context) error {
+			return logger.Configure(ctx.String("log-level"))
 		},
 		Action: startServer,
+		Commands: []*cli.Command{
+			crawlCommand,
+			estimateCommand,
+			statusCommand,
+			dbCommand,
+		},
 	}
 
 	err := app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
+		logger.Default().With("error", err).Errorf("exit")
+		os.Exit(1)
 	}
 }
 
-func startServer(ctx *cli.Context) error {
+func connectDB(ctx *cli.Context) (*sqlx.DB, error) {
+	if ctx.String("postgres-password") == "" {
+		return nil, errors.New("Required flag \"postgres-password\" not set")
+	}
 	dataSrcName := fmt.Sprintf(
 		"postgres://%s:%s@%s:%s/%s",
 		ctx.String("postgres-user"),
@@ -49,130 +77,104 @@ func startServer(ctx *cli.Context) error {
 	)
 	db, err := sqlx.Connect("pgx", dataSrcName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if err = db.Ping(); err != nil {
-		return err
+		db.Close()
+		return nil, err
 	}
 
-	c := cron.New()
-	// Одежда
-	c.AddFunc("21 0 * * *", func() {
-		if err := service.CrawlProductList(db, 5235); err != nil {
-			log.Printf("ERROR: CrawlProductList, %v\n", err)
-		}
+	return db, nil
+}
 
-		log.Println("INFO: Run crawl products")
-		if err := service.CrawlProducts(db, 5235); err != nil {
-			log.Printf("ERROR: CrawlProducts, %v\n", err)
-		}
-		log.Println("DONE")
-	})
-	// для взрослых
-	c.AddFunc("19 2 * * *", func() {
-		if err := service.CrawlProductList(db, 7304); err != nil {
-			log.Printf("ERROR: CrawlProductList, %v\n", err)
+func startServer(ctx *cli.Context) error {
+	conn, err := connectDB(ctx)
+	if err != nil {
+		return err
+	}
+	// database is closed after running jobs are stopped, see below
+	defer conn.Close()
+	if ctx.Bool("check-schema") {
+		if err := db.CheckSchema(ctx.Context, conn); err != nil {
+			return err
 		}
+	}
 
-		log.Println("INFO: Run crawl products")
-		if err := service.CrawlProducts(db, 7304); err != nil {
-			log.Printf("ERROR: CrawlProducts, %v\n", err)
-		}
-		log.Println("DONE")
-	})
-	// Спорт и отдых
-	c.AddFunc("11 3 * * *", func() {
-		if err := service.CrawlProductList(db, 7331); err != nil {
-			log.Printf("ERROR: CrawlProductList, %v\n", err)
-		}
+	client, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
 
-		log.Println("INFO: Run crawl products")
-		if err := service.CrawlProducts(db, 7331); err != nil {
-			log.Printf("ERROR: CrawlProducts, %v\n", err)
-		}
-		log.Println("DONE")
-	})
-	// Товары для дома
-	c.AddFunc("13 4 * * *", func() {
-		if err := service.CrawlProductList(db, 6260); err != nil {
-			log.Printf("ERROR: CrawlProductList, %v\n", err)
-		}
+	jobsCtx, cancelJobs := context.WithCancel(ctx.Context)
+	defer cancelJobs()
 
-		log.Println("INFO: Run crawl products")
-		if err := service.CrawlProducts(db, 6260); err != nil {
-			log.Printf("ERROR: CrawlProducts, %v\n", err)
-		}
-		log.Println("DONE")
-	})
-	// Акссесуары
-	c.AddFunc("11 6 * * *", func() {
-		if err := service.CrawlProductList(db, 5675); err != nil {
-			log.Printf("ERROR: CrawlProductList, %v\n", err)
-		}
+	sched := &scheduler{
+		ctx:    jobsCtx,
+		db:     conn,
+		client: client,
+		path:   ctx.String("config"),
+	}
+	if err := sched.start(); err != nil {
+		return err
+	}
 
-		log.Println("INFO: Run crawl products")
-		if err := service.CrawlProducts(db, 5675); err != nil {
-			log.Printf("ERROR: CrawlProducts, %v\n", err)
-		}
-		log.Println("DONE")
-	})
-	// красота 5919
-	c.AddFunc("37 8 * * *", func() {
-		if err := service.CrawlProductList(db, 5919); err != nil {
-			log.Printf("ERROR: CrawlProductList, %v\n", err)
-		}
+	prometheus.MustRegister(collectors.NewDBStatsCollector(conn.DB, ctx.String("postgres-db")))
+	var status *statusServer
+	if addr := ctx.String("status-addr"); addr != "" {
+		status = newStatusServer(addr, conn, sched)
+		status.start()
+	}
 
-		log.Println("INFO: Run crawl products")
-		if err := service.CrawlProducts(db, 5919); err != nil {
-			log.Printf("ERROR: CrawlProducts, %v\n", err)
+	signalChan := make(chan os.Signal, 1)
+	// SIGTERM is called when Ctrl+C was pressed, SIGHUP reloads config
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signalChan {
+		if sig != syscall.SIGHUP {
+			break
 		}
-		log.Println("DONE")
-	})
-	// Книги 7954
-	c.AddFunc("01 18 * * *", func() {
-		if err := service.CrawlProductList(db, 7954); err != nil {
-			log.Printf("ERROR: CrawlProductList, %v\n", err)
+		logger.Default().Infof("reload %s", sched.path)
+		if err := sched.start(); err != nil {
+			logger.Default().With("error", err).Errorf("reload failed, keep previous schedule")
 		}
+	}
 
-		log.Println("INFO: Run crawl products")
-		if err := service.CrawlProducts(db, 7954); err != nil {
-			log.Printf("ERROR: CrawlProducts, %v\n", err)
-		}
-		log.Println("DONE")
-	})
-	// Бытовая техника
-	c.AddFunc("11 19 * * *", func() {
-		if err := service.CrawlCategories(db); err != nil {
-			log.Printf("ERROR: CrawlCategories, %v\n", err)
+	// running jobs stop at a safe point leaving their checkpoints,
+	// database is closed only when they are finished
+	logger.Default().Infof("shutting down, waiting for running jobs")
+	cancelJobs()
+	sched.stop()
+	if status != nil {
+		status.stop()
+	}
+	logger.Default().Infof("stopped")
 
-		}
-		if err := service.CrawlProductList(db, 5087); err != nil {
-			log.Printf("ERROR: CrawlProductList, %v\n", err)
-		}
-		if err := service.CrawlProducts(db, 5087); err != nil {
-			log.Printf("ERROR: CrawlProductList, %v\n", err)
-		}
-		log.Println("DONE")
-	})
-	// Зоотовары
-	c.AddFunc("11 21 * * *", func() {
-		if err := service.CrawlProductList(db, 7827); err != nil {
-			log.Printf("ERROR: CrawlProductList, %v\n", err)
-		}
-		if err := service.CrawlProducts(db, 7827); err != nil {
-			log.Printf("ERROR: CrawlProductList, %v\n", err)
-		}
-		log.Println("DONE")
-	})
-	c.Start()
+	return nil
+}
 
-	signalChan := make(chan os.Signal, 1)
-	// SIGTERM is called when Ctrl+C was pressed
-	signal.Notify(signalChan, os.Interrupt, os.Kill, syscall.SIGTERM)
-	<-signalChan
+func newClient(ctx *cli.Context) (service.MarketplaceClient, error) {
+	retry := service.DefaultRetryPolicy
+	retry.MaxAttempts = ctx.Int("max-attempts")
+	opts := service.ClientOptions{
+		BaseURL: ctx.String("api-url"),
+		Limiter: service.NewLimiter(ctx.Float64("rps"), ctx.Int("max-inflight")),
+		Retry:   retry,
+	}
 
-	//	c.Stop()
-	db.Close()
+	archive := service.NewArchive(ctx.String("archive-dir"))
+	switch ctx.String("archive-mode") {
+	case "off":
+	case "record":
+		opts.Transport = service.NewRecordTransport(archive, nil)
+	case "replay":
+		var at time.Time
+		if ts := ctx.Timestamp("replay-at"); ts != nil {
+			at = *ts
+		}
+		opts.Transport = service.NewReplayTransport(archive, at)
+		opts.Limiter = nil
+	default:
+		return nil, fmt.Errorf("unknown archive mode: %s", ctx.String("archive-mode"))
+	}
 
-	return nil
+	return service.NewKazanExpressClient(opts), nil
 }
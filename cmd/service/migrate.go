@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/isqad/kexpress/db"
+	"github.com/isqad/kexpress/internal/logger"
+	"github.com/urfave/cli/v2"
+)
+
+var dbCommand = &cli.Command{
+	Name:  "db",
+	Usage: "manage database schema",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "migrate",
+			Usage: "apply migrations embedded in the binary",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "up",
+					Usage: "apply all pending migrations",
+					Action: withMigrate(func(ctx *cli.Context, m *migrate.Migrate) error {
+						return ignoreNoChange(m.Up())
+					}),
+				},
+				{
+					Name:  "down",
+					Usage: "roll back applied migrations",
+					Flags: []cli.Flag{
+						&cli.IntFlag{Name: "steps", Value: 1, Usage: "number of migrations to roll back"},
+					},
+					Action: withMigrate(func(ctx *cli.Context, m *migrate.Migrate) error {
+						if ctx.Int("steps") < 1 {
+							return errors.New("steps must be positive")
+						}
+						return ignoreNoChange(m.Steps(-ctx.Int("steps")))
+					}),
+				},
+				{
+					Name:  "status",
+					Usage: "print applied and latest schema versions",
+					Action: withMigrate(func(ctx *cli.Context, m *migrate.Migrate) error {
+						version, dirty, err := m.Version()
+						if err != nil && err != migrate.ErrNilVersion {
+							return err
+						}
+						versions, err := db.Versions()
+						if err != nil {
+							return err
+						}
+						var latest uint
+						pending := 0
+						for _, v := range versions {
+							if v > version {
+								pending++
+							}
+							latest = v
+						}
+
+						w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+						fmt.Fprintln(w, "VERSION\tDIRTY\tLATEST\tPENDING")
+						fmt.Fprintf(w, "%d\t%t\t%d\t%d\n", version, dirty, latest, pending)
+						return w.Flush()
+					}),
+				},
+				{
+					Name:      "force",
+					Usage:     "set schema version without running migrations, clears dirty state",
+					ArgsUsage: "VERSION",
+					Action: withMigrate(func(ctx *cli.Context, m *migrate.Migrate) error {
+						version, err := strconv.Atoi(ctx.Args().First())
+						if err != nil {
+							return fmt.Errorf("invalid version %q", ctx.Args().First())
+						}
+						return m.Force(version)
+					}),
+				},
+			},
+		},
+	},
+}
+
+// withMigrate connects to database and creates migrator of embedded migrations for the action
+func withMigrate(action func(*cli.Context, *migrate.Migrate) error) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		conn, err := connectDB(ctx)
+		if err != nil {
+			return err
+		}
+
+		m, err := db.NewMigrate(conn)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		defer m.Close()
+		m.Log = migrateLog{logger.Default()}
+
+		return action(ctx, m)
+	}
+}
+
+func ignoreNoChange(err error) error {
+	if err == migrate.ErrNoChange {
+		logger.Default().Infof("no change")
+		return nil
+	}
+	return err
+}
+
+// migrateLog writes migrator output to logger
+type migrateLog struct {
+	l *logger.Logger
+}
+
+func (m migrateLog) Printf(format string, v ...interface{}) {
+	m.l.Infof(strings.TrimRight(format, "\n"), v...)
+}
+
+func (m migrateLog) Verbose() bool {
+	return m.l.Enabled(logger.Debug)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/isqad/kexpress/internal/logger"
+	"github.com/isqad/kexpress/internal/schedule"
+	"github.com/isqad/kexpress/internal/service"
+	"github.com/jmoiron/sqlx"
+	"github.com/robfig/cron/v3"
+)
+
+// scheduler runs crawl jobs from config file
+type scheduler struct {
+	// ctx is canceled on shutdown to interrupt running jobs
+	ctx    context.Context
+	db     *sqlx.DB
+	client service.MarketplaceClient
+	path   string
+
+	mu      sync.Mutex
+	cron    *cron.Cron
+	entries map[cron.EntryID]*schedule.Job
+	stopped bool
+	// running counts jobs of current and replaced schedules
+	running sync.WaitGroup
+	// startedAt is start time of jobs running at the moment by name
+	startedAt map[string]time.Time
+}
+
+// jobStatus is scheduled job with its fire times
+type jobStatus struct {
+	Name      string     `json:"name"`
+	Schedule  string     `json:"schedule"`
+	Roots     []int64    `json:"roots"`
+	Stages    []string   `json:"stages"`
+	Next      time.Time  `json:"next"`
+	Prev      *time.Time `json:"prev"`
+	Running   bool       `json:"running"`
+	StartedAt *time.Time `json:"startedAt"`
+}
+
+// start loads config and replaces running schedule with the loaded one.
+// Jobs running at the moment are not interrupted.
+func (s *scheduler) start() error {
+	cfg, err := schedule.Load(s.path)
+	if err != nil {
+		return err
+	}
+
+	c := cron.New()
+	entries := make(map[cron.EntryID]*schedule.Job, len(cfg.Jobs))
+	for _, job := range cfg.Jobs {
+		job := job
+		id, err := c.AddFunc(job.Schedule, func() { s.run(job) })
+		if err != nil {
+			return err
+		}
+		entries[id] = job
+		logger.Default().With("job", job.Name).Infof("job scheduled at %q, roots: %v, stages: %v", job.Schedule, job.Roots, job.Stages)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return nil
+	}
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+	s.cron = c
+	s.entries = entries
+	c.Start()
+
+	return nil
+}
+
+// jobs returns scheduled jobs in order of their next run
+func (s *scheduler) jobs() []*jobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := []*jobStatus{}
+	if s.cron == nil {
+		return jobs
+	}
+	for _, e := range s.cron.Entries() {
+		job, ok := s.entries[e.ID]
+		if !ok {
+			continue
+		}
+		js := &jobStatus{
+			Name:     job.Name,
+			Schedule: job.Schedule,
+			Roots:    job.Roots,
+			Stages:   job.Stages,
+			Next:     e.Next,
+		}
+		if !e.Prev.IsZero() {
+			prev := e.Prev
+			js.Prev = &prev
+		}
+		if startedAt, ok := s.startedAt[job.Name]; ok {
+			js.Running = true
+			js.StartedAt = &startedAt
+		}
+		jobs = append(jobs, js)
+	}
+
+	return jobs
+}
+
+// run runs job unless the scheduler is stopped
+func (s *scheduler) run(job *schedule.Job) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.running.Add(1)
+	_, overlaps := s.startedAt[job.Name]
+	if !overlaps {
+		if s.startedAt == nil {
+			s.startedAt = map[string]time.Time{}
+		}
+		s.startedAt[job.Name] = time.Now()
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if !overlaps {
+			delete(s.startedAt, job.Name)
+		}
+		s.mu.Unlock()
+		s.running.Done()
+	}()
+
+	runJob(s.ctx, s.db, s.client, job)
+}
+
+// stop stops the schedule and waits for running jobs.
+// Jobs are expected to be interrupted by canceling the scheduler context.
+func (s *scheduler) stop() {
+	s.mu.Lock()
+	s.stopped = true
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+	s.mu.Unlock()
+
+	s.running.Wait()
+}
+
+// lockTimeout bounds acquiring job lock, e.g. when database connection stalls
+const lockTimeout = 30 * time.Second
+
+// runJob runs job unless it is already running in another replica.
+// Remaining stages are skipped when ctx is done.
+func runJob(ctx context.Context, db *sqlx.DB, client service.MarketplaceClient, job *schedule.Job) {
+	l := logger.FromContext(ctx).With("job", job.Name)
+	ctx = logger.NewContext(ctx, l)
+
+	lockCtx, cancel := context.WithTimeout(ctx, lockTimeout)
+	lock, err := service.TryLockJob(lockCtx, db, job.Name)
+	cancel()
+	if err != nil {
+		l.With("error", err).Errorf("lock job failed")
+		return
+	}
+	if lock == nil {
+		l.Infof("job is running in another replica, skip")
+		return
+	}
+	defer func() {
+		if err := lock.Unlock(ctx); err != nil {
+			l.With("error", err).Errorf("unlock job failed")
+		}
+	}()
+
+	l.Infof("run job")
+
+	if job.HasStage(service.StageCategories) {
+		if err := service.CrawlCategories(ctx, db, client); err != nil {
+			l.With("error", err).Errorf("crawl categories failed")
+		}
+	}
+
+	opts := job.CrawlOptions()
+	for _, root := range job.Roots {
+		if ctx.Err() != nil {
+			l.Infof("job interrupted")
+			return
+		}
+
+		if job.HasStage(service.StageListing) {
+			if err := service.CrawlProductList(ctx, db, client, root, opts); err != nil {
+				l.With("error", err, "root_category_id", root).Errorf("crawl product list failed")
+			}
+		}
+
+		if job.HasStage(service.StageProducts) {
+			if err := service.CrawlProducts(ctx, db, client, root, opts); err != nil {
+				l.With("error", err, "root_category_id", root).Errorf("crawl products failed")
+			}
+		}
+
+		if job.HasStage(service.StageSales) {
+			now := time.Now()
+			if err := service.EstimateCategorySales(ctx, db, root, now.AddDate(0, 0, -service.SalesWindow), now); err != nil {
+				l.With("error", err, "root_category_id", root).Errorf("estimate sales failed")
+			}
+		}
+	}
+
+	l.Infof("job done")
+}
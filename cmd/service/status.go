@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/isqad/kexpress/db"
+	"github.com/isqad/kexpress/internal/logger"
+	"github.com/isqad/kexpress/internal/service"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// statusServer serves probes, metrics and status of the crawler
+type statusServer struct {
+	server *http.Server
+	db     *sqlx.DB
+	sched  *scheduler
+}
+
+func newStatusServer(addr string, db *sqlx.DB, sched *scheduler) *statusServer {
+	s := &statusServer{db: db, sched: sched}
+
+	r := chi.NewRouter()
+	r.Get("/healthz", s.healthz)
+	r.Get("/readyz", s.readyz)
+	r.Get("/status", s.status)
+	r.Method("GET", "/metrics", promhttp.Handler())
+
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           r,
+		ReadHeaderTimeout: 1 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+	return s
+}
+
+// start serves requests in background
+func (s *statusServer) start() {
+	go func() {
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Default().With("error", err).Errorf("status server failed")
+		}
+	}()
+	logger.Default().Infof("status server listens on %s", s.server.Addr)
+}
+
+// stop drains in-flight requests
+func (s *statusServer) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		logger.Default().With("error", err).Errorf("status server shutdown failed")
+	}
+}
+
+// healthz responds while the process serves requests
+func (s *statusServer) healthz(w http.ResponseWriter, r *http.Request) {
+	writeStatusJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz responds with unavailable status if database is not ready, see db.CheckReady
+func (s *statusServer) readyz(w http.ResponseWriter, r *http.Request) {
+	if _, err := db.CheckReady(r.Context(), s.db); err != nil {
+		writeStatusJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready", "error": err.Error()})
+		return
+	}
+
+	writeStatusJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// crawlerStatus is scheduled jobs with runs in progress
+type crawlerStatus struct {
+	Jobs []*jobStatus        `json:"jobs"`
+	Runs []*service.CrawlRun `json:"runs"`
+}
+
+// status responds with scheduled jobs and progress of running stages
+func (s *statusServer) status(w http.ResponseWriter, r *http.Request) {
+	writeStatusJSON(w, http.StatusOK, &crawlerStatus{
+		Jobs: s.sched.jobs(),
+		Runs: service.RunningCrawlRuns(),
+	})
+}
+
+func writeStatusJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Default().With("error", err).Errorf("write response failed")
+	}
+}
@@ -0,0 +1,133 @@
+// Package db embeds SQL migrations of the database schema
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/pgx"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jmoiron/sqlx"
+)
+
+// Migrations are migration files named <version>_<name>.<up|down>.sql
+//
+//go:embed migrate/*.sql
+var Migrations embed.FS
+
+// NewMigrate returns migrator applying embedded migrations to database.
+// Closing the migrator closes the database.
+func NewMigrate(db *sqlx.DB) (*migrate.Migrate, error) {
+	src, err := iofs.New(Migrations, "migrate")
+	if err != nil {
+		return nil, err
+	}
+	driver, err := pgx.WithInstance(db.DB, &pgx.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.NewWithInstance("iofs", src, "pgx", driver)
+}
+
+// Versions returns versions of embedded migrations in ascending order
+func Versions() ([]uint, error) {
+	files, err := fs.Glob(Migrations, "migrate/*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]uint, 0, len(files))
+	for _, f := range files {
+		name := strings.TrimPrefix(f, "migrate/")
+		i := strings.Index(name, "_")
+		if i < 0 {
+			return nil, fmt.Errorf("migration %s has no version", name)
+		}
+		v, err := strconv.ParseUint(name[:i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", name, err)
+		}
+		versions = append(versions, uint(v))
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	return versions, nil
+}
+
+// LatestVersion returns version of the last embedded migration
+func LatestVersion() (uint, error) {
+	versions, err := Versions()
+	if err != nil || len(versions) == 0 {
+		return 0, err
+	}
+	return versions[len(versions)-1], nil
+}
+
+// SchemaVersion returns version of the last migration applied to database
+// and whether it failed half way. Zero version means no migrations applied.
+func SchemaVersion(ctx context.Context, db *sqlx.DB) (uint, bool, error) {
+	var v struct {
+		Version int64 `db:"version"`
+		Dirty   bool  `db:"dirty"`
+	}
+	err := db.GetContext(ctx, &v, `SELECT version, dirty FROM schema_migrations LIMIT 1`)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return uint(v.Version), v.Dirty, nil
+}
+
+// CheckSchema returns error if database schema is dirty or behind the latest
+// embedded migration. Schema ahead of the binary is accepted, so replicas of
+// the previous release keep running while the next one is rolled out.
+func CheckSchema(ctx context.Context, db *sqlx.DB) error {
+	latest, err := LatestVersion()
+	if err != nil {
+		return err
+	}
+	version, dirty, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty, fix it and run `kexpress db migrate force`", version)
+	}
+	if version < latest {
+		return fmt.Errorf("schema version %d is behind %d, run `kexpress db migrate up`", version, latest)
+	}
+
+	return nil
+}
+
+// readyTimeout limits database checks of readiness probes
+const readyTimeout = 2 * time.Second
+
+// CheckReady returns schema version of database if it is reachable and its schema
+// passes CheckSchema. It backs readiness probes of the binaries.
+func CheckReady(ctx context.Context, db *sqlx.DB) (uint, error) {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		return 0, fmt.Errorf("database unavailable: %w", err)
+	}
+	if err := CheckSchema(ctx, db); err != nil {
+		return 0, err
+	}
+	version, _, err := SchemaVersion(ctx, db)
+
+	return version, err
+}
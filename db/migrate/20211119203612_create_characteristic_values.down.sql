@@ -0,0 +1 @@
+DROP TABLE char_values;
@@ -0,0 +1,3 @@
+-- skus without characteristic value can't be kept
+DELETE FROM skus WHERE char_value_id IS NULL;
+ALTER TABLE skus ALTER COLUMN char_value_id SET NOT NULL;
@@ -0,0 +1,9 @@
+-- skus of one product sharing characteristic value after rollback of
+-- sku_char_values can't be told apart, only the first of them is kept
+DELETE FROM skus USING skus first
+WHERE skus.product_id = first.product_id
+  AND skus.char_value_id = first.char_value_id
+  AND skus.id > first.id;
+
+ALTER TABLE skus ADD CONSTRAINT uniq_product_id_char_value_id UNIQUE
+  (product_id, char_value_id);
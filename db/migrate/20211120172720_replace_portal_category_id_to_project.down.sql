@@ -0,0 +1,6 @@
+BEGIN;
+DROP INDEX index_products_category_id;
+ALTER TABLE products DROP CONSTRAINT fk_products_categories;
+ALTER TABLE products DROP COLUMN category_id;
+ALTER TABLE products RENAME COLUMN portal_category_id TO category_id;
+COMMIT;
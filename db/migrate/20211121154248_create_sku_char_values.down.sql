@@ -0,0 +1,10 @@
+ALTER TABLE skus DROP CONSTRAINT fk_skus_product_id;
+DROP INDEX index_skus_product_id;
+
+-- skus keep one of their characteristic values
+ALTER TABLE skus ADD COLUMN char_value_id bigint;
+UPDATE skus SET char_value_id = v.char_value_id
+FROM (SELECT sku_id, min(char_value_id) AS char_value_id FROM sku_char_values GROUP BY sku_id) v
+WHERE v.sku_id = skus.id;
+
+DROP TABLE sku_char_values;
@@ -0,0 +1,5 @@
+DROP INDEX uniq_products_fingerprint;
+ALTER TABLE products DROP COLUMN fingerprint;
+
+-- raw responses are not restored
+ALTER TABLE products ADD COLUMN raw_json jsonb;
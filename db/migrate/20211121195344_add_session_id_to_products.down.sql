@@ -0,0 +1,2 @@
+ALTER TABLE products DROP CONSTRAINT uniq_portal_id_session_id_products;
+ALTER TABLE products DROP COLUMN session_id;
@@ -0,0 +1,4 @@
+ALTER TABLE products DROP COLUMN fetch_attempts;
+ALTER TABLE products DROP COLUMN fetch_error;
+ALTER TABLE products DROP COLUMN fetch_error_permanent;
+ALTER TABLE products DROP COLUMN fetch_failed_at;
@@ -0,0 +1,4 @@
+ALTER TABLE products ADD COLUMN fetch_attempts int NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN fetch_error text;
+ALTER TABLE products ADD COLUMN fetch_error_permanent boolean NOT NULL DEFAULT false;
+ALTER TABLE products ADD COLUMN fetch_failed_at timestamp with time zone;
@@ -0,0 +1,19 @@
+CREATE TABLE crawl_runs (
+  id bigint NOT NULL PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+  job varchar(255) NOT NULL,
+  root_category_id bigint NOT NULL DEFAULT 0,
+  stage varchar(32) NOT NULL,
+  session_id bigint NOT NULL DEFAULT 0,
+  status varchar(32) NOT NULL,
+  error text,
+  pages_fetched int NOT NULL DEFAULT 0,
+  pages_failed int NOT NULL DEFAULT 0,
+  products_discovered int NOT NULL DEFAULT 0,
+  products_parsed int NOT NULL DEFAULT 0,
+  products_deduplicated int NOT NULL DEFAULT 0,
+  products_failed int NOT NULL DEFAULT 0,
+  started_at timestamp with time zone NOT NULL,
+  finished_at timestamp with time zone
+);
+
+CREATE INDEX index_crawl_runs_job_started_at ON crawl_runs (job, started_at DESC);
@@ -0,0 +1,2 @@
+DROP INDEX index_crawl_runs_root_category_id_stage;
+DROP TABLE crawl_checkpoints;
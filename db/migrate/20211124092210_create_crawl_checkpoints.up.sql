@@ -0,0 +1,12 @@
+CREATE TABLE crawl_checkpoints (
+  session_id bigint NOT NULL,
+  stage varchar(32) NOT NULL,
+  category_id bigint NOT NULL,
+  last_page int NOT NULL DEFAULT -1,
+  last_product_id bigint NOT NULL DEFAULT 0,
+  done boolean NOT NULL DEFAULT false,
+  updated_at timestamp with time zone NOT NULL,
+  PRIMARY KEY (session_id, stage, category_id)
+);
+
+CREATE INDEX index_crawl_runs_root_category_id_stage ON crawl_runs (root_category_id, stage, started_at DESC);
@@ -0,0 +1,4 @@
+DROP INDEX index_products_unparsed;
+
+ALTER TABLE products DROP COLUMN claimed_by;
+ALTER TABLE products DROP COLUMN claimed_at;
@@ -0,0 +1,4 @@
+ALTER TABLE products ADD COLUMN claimed_by varchar(255);
+ALTER TABLE products ADD COLUMN claimed_at timestamp with time zone;
+
+CREATE INDEX index_products_unparsed ON products (category_id, id) WHERE parsed_at IS NULL;
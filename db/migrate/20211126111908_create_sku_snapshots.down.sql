@@ -0,0 +1,3 @@
+DROP TABLE sku_snapshots;
+
+ALTER TABLE skus DROP COLUMN portal_id;
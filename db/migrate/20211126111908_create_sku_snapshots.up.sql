@@ -0,0 +1,18 @@
+ALTER TABLE skus ADD COLUMN portal_id bigint;
+
+CREATE TABLE sku_snapshots (
+  id bigint NOT NULL PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+  portal_sku_id bigint NOT NULL,
+  product_portal_id bigint NOT NULL,
+  session_id bigint NOT NULL,
+  available_amount int NOT NULL DEFAULT 0,
+  full_price numeric(12, 2) NOT NULL DEFAULT 0,
+  purchase_price numeric(12, 2) NOT NULL DEFAULT 0,
+  observed_at timestamp with time zone NOT NULL
+);
+
+COMMENT ON TABLE sku_snapshots IS 'Price and stock of SKU observed in crawl session';
+
+ALTER TABLE sku_snapshots ADD CONSTRAINT uniq_portal_sku_id_session_id_sku_snapshots UNIQUE
+  (portal_sku_id, session_id);
+CREATE INDEX index_sku_snapshots_product_portal_id ON sku_snapshots (product_portal_id, observed_at);
@@ -0,0 +1,16 @@
+CREATE TABLE product_daily_sales (
+  day date NOT NULL,
+  product_portal_id bigint NOT NULL,
+  category_id bigint NOT NULL,
+  seller_id bigint NOT NULL DEFAULT 0,
+  units numeric(12, 2) NOT NULL DEFAULT 0,
+  price numeric(12, 2) NOT NULL DEFAULT 0,
+  revenue numeric(14, 2) NOT NULL DEFAULT 0,
+  updated_at timestamp with time zone NOT NULL,
+  PRIMARY KEY (day, product_portal_id)
+);
+
+COMMENT ON TABLE product_daily_sales IS 'Units sold and revenue estimated from consecutive product observations';
+
+CREATE INDEX index_product_daily_sales_category_id ON product_daily_sales (category_id, day);
+CREATE INDEX index_product_daily_sales_seller_id ON product_daily_sales (seller_id, day);
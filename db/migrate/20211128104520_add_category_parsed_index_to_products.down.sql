@@ -0,0 +1 @@
+DROP INDEX index_products_category_id_parsed_at;
@@ -0,0 +1 @@
+CREATE INDEX index_products_category_id_parsed_at ON products (category_id, parsed_at) WHERE parsed_at IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP INDEX index_products_seller_id;
+DROP TABLE seller_snapshots;
+DROP TABLE sellers;
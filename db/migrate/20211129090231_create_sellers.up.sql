@@ -0,0 +1,29 @@
+CREATE TABLE sellers (
+  id bigint NOT NULL PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+  portal_id bigint NOT NULL,
+  title varchar(1024),
+  orders integer NOT NULL DEFAULT 0,
+  reviews integer NOT NULL DEFAULT 0,
+  rating numeric(3, 2) NOT NULL DEFAULT 0,
+  created_at timestamp with time zone NOT NULL,
+  updated_at timestamp with time zone NOT NULL
+);
+
+ALTER TABLE sellers ADD CONSTRAINT uniq_portal_id_sellers UNIQUE (portal_id);
+
+CREATE TABLE seller_snapshots (
+  id bigint NOT NULL PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+  seller_portal_id bigint NOT NULL,
+  session_id bigint NOT NULL,
+  orders integer NOT NULL DEFAULT 0,
+  reviews integer NOT NULL DEFAULT 0,
+  rating numeric(3, 2) NOT NULL DEFAULT 0,
+  observed_at timestamp with time zone NOT NULL
+);
+
+COMMENT ON TABLE seller_snapshots IS 'Rating, orders and reviews of seller observed in crawl session';
+
+ALTER TABLE seller_snapshots ADD CONSTRAINT uniq_seller_portal_id_session_id_seller_snapshots UNIQUE
+  (seller_portal_id, session_id);
+CREATE INDEX index_seller_snapshots_seller_portal_id ON seller_snapshots (seller_portal_id, observed_at);
+CREATE INDEX index_products_seller_id ON products (seller_id, parsed_at) WHERE parsed_at IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP INDEX index_products_search_vector;
+
+ALTER TABLE products DROP COLUMN search_vector;
@@ -0,0 +1,7 @@
+ALTER TABLE products ADD COLUMN search_vector tsvector;
+
+UPDATE products SET search_vector =
+  setweight(to_tsvector('russian', COALESCE(title, '')), 'A') ||
+  setweight(to_tsvector('russian', COALESCE(description, '')), 'B');
+
+CREATE INDEX index_products_search_vector ON products USING GIN (search_vector);
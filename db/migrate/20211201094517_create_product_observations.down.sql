@@ -0,0 +1,16 @@
+ALTER TABLE crawl_runs RENAME COLUMN products_unchanged TO products_deduplicated;
+
+ALTER TABLE products ADD COLUMN fetch_attempts int NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN fetch_error text;
+ALTER TABLE products ADD COLUMN fetch_error_permanent boolean NOT NULL DEFAULT false;
+ALTER TABLE products ADD COLUMN fetch_failed_at timestamp with time zone;
+ALTER TABLE products ADD COLUMN claimed_by varchar(255);
+ALTER TABLE products ADD COLUMN claimed_at timestamp with time zone;
+CREATE INDEX index_products_unparsed ON products (category_id, id) WHERE parsed_at IS NULL;
+
+ALTER TABLE products DROP CONSTRAINT uniq_portal_id_products;
+ALTER TABLE products ADD CONSTRAINT uniq_portal_id_session_id_products UNIQUE
+  (portal_id, session_id);
+
+-- observations of earlier sessions are lost, products keep their last state
+DROP TABLE product_observations;
@@ -0,0 +1,63 @@
+CREATE TABLE product_observations (
+  id bigint NOT NULL PRIMARY KEY GENERATED BY DEFAULT AS IDENTITY,
+  product_id bigint NOT NULL,
+  session_id bigint NOT NULL,
+  category_id bigint,
+  orders_amount integer NOT NULL DEFAULT 0,
+  reviews_amount integer NOT NULL DEFAULT 0,
+  total_available_amount integer NOT NULL DEFAULT 0,
+  rating numeric(3, 2) NOT NULL DEFAULT 0,
+  price numeric(12, 2) NOT NULL DEFAULT 0,
+  fingerprint varchar(1024) NOT NULL DEFAULT '',
+  unchanged boolean NOT NULL DEFAULT false,
+  claimed_by varchar(255),
+  claimed_at timestamp with time zone,
+  fetch_attempts int NOT NULL DEFAULT 0,
+  fetch_error text,
+  fetch_error_permanent boolean NOT NULL DEFAULT false,
+  fetch_failed_at timestamp with time zone,
+  created_at timestamp with time zone NOT NULL,
+  observed_at timestamp with time zone
+);
+
+COMMENT ON TABLE product_observations IS 'Product seen in crawl session, pending until its card is fetched';
+COMMENT ON COLUMN product_observations.unchanged IS 'Card is the same as the last saved one, product is not saved again';
+
+-- every row of products becomes observation of the last parsed row of its product
+INSERT INTO product_observations
+  (product_id, session_id, category_id, orders_amount, reviews_amount, total_available_amount, rating, price,
+   fingerprint, fetch_attempts, fetch_error, fetch_error_permanent, fetch_failed_at, created_at, observed_at)
+SELECT canonical.id, p.session_id, p.category_id, p.orders_amount, p.reviews_amount, p.total_available_amount, p.rating,
+  COALESCE((SELECT AVG(skus.purchase_price) FROM skus WHERE skus.product_id = p.id), 0),
+  p.fingerprint, p.fetch_attempts, p.fetch_error, p.fetch_error_permanent, p.fetch_failed_at, p.created_at, p.parsed_at
+FROM products p
+JOIN (
+  SELECT DISTINCT ON (portal_id) id, portal_id FROM products
+  ORDER BY portal_id, parsed_at DESC NULLS LAST, id DESC
+) canonical ON canonical.portal_id = p.portal_id;
+
+DELETE FROM products p USING (
+  SELECT DISTINCT ON (portal_id) id, portal_id FROM products
+  ORDER BY portal_id, parsed_at DESC NULLS LAST, id DESC
+) canonical WHERE canonical.portal_id = p.portal_id AND canonical.id != p.id;
+
+ALTER TABLE product_observations ADD CONSTRAINT uniq_product_id_session_id_product_observations UNIQUE
+  (product_id, session_id);
+ALTER TABLE product_observations ADD CONSTRAINT fk_product_observations_product_id
+  FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE;
+CREATE INDEX index_product_observations_pending ON product_observations (category_id, id) WHERE observed_at IS NULL;
+CREATE INDEX index_product_observations_category_id ON product_observations (category_id, observed_at);
+CREATE INDEX index_product_observations_product_id ON product_observations (product_id, observed_at);
+
+ALTER TABLE products DROP CONSTRAINT uniq_portal_id_session_id_products;
+ALTER TABLE products ADD CONSTRAINT uniq_portal_id_products UNIQUE (portal_id);
+
+DROP INDEX index_products_unparsed;
+ALTER TABLE products DROP COLUMN claimed_by;
+ALTER TABLE products DROP COLUMN claimed_at;
+ALTER TABLE products DROP COLUMN fetch_attempts;
+ALTER TABLE products DROP COLUMN fetch_error;
+ALTER TABLE products DROP COLUMN fetch_error_permanent;
+ALTER TABLE products DROP COLUMN fetch_failed_at;
+
+ALTER TABLE crawl_runs RENAME COLUMN products_deduplicated TO products_unchanged;
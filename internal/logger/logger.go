@@ -0,0 +1,224 @@
+// Package logger writes leveled JSON lines with structured fields
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is severity of log line
+type Level int
+
+// Levels in order of severity
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses level name, see Level.String
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q, must be one of %v", s, levelNames)
+}
+
+// output is destination shared by logger and its children
+type output struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger writes lines of the level or above as JSON objects with time, level,
+// message and fields of the logger. Logger is safe for concurrent use.
+type Logger struct {
+	out    *output
+	fields []field
+}
+
+// New returns logger writing lines of the level or above to w
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{out: &output{w: w, level: level}}
+}
+
+// With returns child logger adding the key value pairs to every line.
+// Values of keys the logger already has are replaced.
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+len(keysAndValues)/2)
+	copy(fields, l.fields)
+
+next:
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		value := keysAndValues[i+1]
+		for j := range fields {
+			if fields[j].key == key {
+				fields[j].value = value
+				continue next
+			}
+		}
+		fields = append(fields, field{key: key, value: value})
+	}
+
+	return &Logger{out: l.out, fields: fields}
+}
+
+// Enabled reports whether lines of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+// Debugf writes debug line
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.logf(Debug, format, args...)
+}
+
+// Infof writes info line
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.logf(Info, format, args...)
+}
+
+// Warnf writes warning line
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.logf(Warn, format, args...)
+}
+
+// Errorf writes error line
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logf(Error, format, args...)
+}
+
+func (l *Logger) logf(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.write(level, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) write(level Level, msg string) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeValue(&buf, time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeValue(&buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeValue(&buf, msg)
+	for _, f := range l.fields {
+		buf.WriteByte(',')
+		writeValue(&buf, f.key)
+		buf.WriteByte(':')
+		writeValue(&buf, f.value)
+	}
+	buf.WriteString("}\n")
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+// writeValue writes v as JSON, errors and values not encodable as JSON are written as strings
+func writeValue(buf *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+// Writer returns writer logging every written line with the level.
+// It lets the standard log package and libraries using it write JSON lines.
+func (l *Logger) Writer(level Level) io.Writer {
+	return &lineWriter{l: l, level: level}
+}
+
+type lineWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	if w.l.Enabled(w.level) {
+		w.l.write(w.level, strings.TrimRight(string(p), "\n"))
+	}
+	return len(p), nil
+}
+
+var (
+	stdMu sync.RWMutex
+	std   = New(os.Stderr, Info)
+)
+
+// Default returns logger used when context has no logger
+func Default() *Logger {
+	stdMu.RLock()
+	defer stdMu.RUnlock()
+	return std
+}
+
+// SetDefault replaces default logger
+func SetDefault(l *Logger) {
+	stdMu.Lock()
+	defer stdMu.Unlock()
+	std = l
+}
+
+type contextKey struct{}
+
+// NewContext returns context carrying the logger
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns logger of the context, default logger if it has none
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+// Configure makes JSON logger of the named level default one and redirects
+// the standard log package to it
+func Configure(levelName string) error {
+	level, err := ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+
+	l := New(os.Stderr, level)
+	SetDefault(l)
+	log.SetFlags(0)
+	log.SetOutput(l.Writer(Info))
+
+	return nil
+}
@@ -0,0 +1,113 @@
+// Package schedule loads crawl jobs from the config file
+package schedule
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/isqad/kexpress/internal/service"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Job is scheduled crawl of root categories
+type Job struct {
+	Name string `yaml:"name"`
+	// Schedule is standard cron expression
+	Schedule string `yaml:"schedule"`
+	// Roots are IDs of root categories
+	Roots []int64 `yaml:"roots"`
+	// Stages to run, all stages if empty
+	Stages []string `yaml:"stages"`
+	// Concurrency is number of categories crawled concurrently
+	Concurrency int `yaml:"concurrency"`
+}
+
+// HasStage reports whether job runs the stage
+func (j *Job) HasStage(stage string) bool {
+	for _, s := range j.Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// CrawlOptions returns options of the job crawls.
+// Interrupted session of the job is resumed on the next run.
+func (j *Job) CrawlOptions() service.CrawlOptions {
+	return service.CrawlOptions{
+		Job:     j.Name,
+		Workers: j.Concurrency,
+		Resume:  true,
+	}
+}
+
+// Config is list of jobs
+type Config struct {
+	Jobs []*Job `yaml:"jobs"`
+}
+
+// Load reads and validates config file
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	names := make(map[string]bool)
+	for i, j := range c.Jobs {
+		if j.Name == "" {
+			return fmt.Errorf("job #%d: name is required", i+1)
+		}
+		if names[j.Name] {
+			return fmt.Errorf("job %s: duplicate name", j.Name)
+		}
+		names[j.Name] = true
+
+		if _, err := cron.ParseStandard(j.Schedule); err != nil {
+			return fmt.Errorf("job %s: schedule: %w", j.Name, err)
+		}
+
+		if len(j.Stages) == 0 {
+			j.Stages = service.Stages
+		}
+		for _, s := range j.Stages {
+			if !isStage(s) {
+				return fmt.Errorf("job %s: unknown stage %s", j.Name, s)
+			}
+		}
+
+		if len(j.Roots) == 0 && (j.HasStage(service.StageListing) || j.HasStage(service.StageProducts) ||
+			j.HasStage(service.StageSales)) {
+			return fmt.Errorf("job %s: roots are required for stages %v", j.Name, j.Stages)
+		}
+		if j.Concurrency < 0 {
+			return fmt.Errorf("job %s: concurrency must not be negative", j.Name)
+		}
+	}
+
+	return nil
+}
+
+func isStage(stage string) bool {
+	for _, s := range service.Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
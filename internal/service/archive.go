@@ -0,0 +1,183 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotArchived is returned when archive has no response for the URL
+var ErrNotArchived = errors.New("response is not archived")
+
+// Archive keeps raw API responses on disk.
+//
+// Responses are keyed by request URI (path and query, without host), so
+// archive recorded against one base URL can be replayed against any other.
+// Each response is gzipped and stored as <dir>/<hh>/<hash>/<unix nano>.json.gz
+// where hash is sha256 of the key and hh is its first byte.
+type Archive struct {
+	dir string
+}
+
+// NewArchive creates archive stored in dir
+func NewArchive(dir string) *Archive {
+	return &Archive{dir: dir}
+}
+
+func (a *Archive) keyDir(key string) string {
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+	return filepath.Join(a.dir, hash[:2], hash)
+}
+
+// Put stores response body fetched at the given time
+func (a *Archive) Put(key string, at time.Time, body []byte) error {
+	dir := a.keyDir(key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Name = key
+	zw.ModTime = at
+	if _, err := zw.Write(body); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	name := filepath.Join(dir, strconv.FormatInt(at.UnixNano(), 10)+".json.gz")
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
+
+// Get returns the latest response body fetched not after the given time.
+// Zero time means the latest response.
+func (a *Archive) Get(key string, at time.Time) ([]byte, error) {
+	entries, err := os.ReadDir(a.keyDir(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotArchived
+		}
+		return nil, err
+	}
+
+	stamps := []int64{}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, ".json.gz") {
+			continue
+		}
+		ts, err := strconv.ParseInt(strings.TrimSuffix(name, ".json.gz"), 10, 64)
+		if err != nil {
+			continue
+		}
+		if !at.IsZero() && ts > at.UnixNano() {
+			continue
+		}
+		stamps = append(stamps, ts)
+	}
+	if len(stamps) == 0 {
+		return nil, ErrNotArchived
+	}
+	sort.Slice(stamps, func(i, j int) bool { return stamps[i] < stamps[j] })
+
+	f, err := os.Open(filepath.Join(a.keyDir(key), strconv.FormatInt(stamps[len(stamps)-1], 10)+".json.gz"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return io.ReadAll(zr)
+}
+
+// NewRecordTransport returns transport writing every successful response
+// fetched through next to the archive
+func NewRecordTransport(archive *Archive, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &recordTransport{archive: archive, next: next}
+}
+
+type recordTransport struct {
+	archive *Archive
+	next    http.RoundTripper
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := t.archive.Put(req.URL.RequestURI(), time.Now(), body); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// NewReplayTransport returns transport serving responses from the archive
+// instead of the network. Responses fetched after the given time are ignored,
+// zero time means the latest ones. Missing responses fail with ErrNotArchived
+// as transport errors, so they are transient and a live crawl fetches them again.
+func NewReplayTransport(archive *Archive, at time.Time) http.RoundTripper {
+	return &replayTransport{archive: archive, at: at}
+}
+
+type replayTransport struct {
+	archive *Archive
+	at      time.Time
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := t.archive.Get(req.URL.RequestURI(), t.at)
+	if err == ErrNotArchived {
+		return nil, fmt.Errorf("%s: %w", req.URL.RequestURI(), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestArchiveGet(t *testing.T) {
+	a := NewArchive(t.TempDir())
+	base := time.Date(2021, 11, 20, 10, 0, 0, 0, time.UTC)
+	key := "/api/v2/product/42"
+
+	for i, body := range []string{`{"v":1}`, `{"v":2}`, `{"v":3}`} {
+		if err := a.Put(key, base.Add(time.Duration(i)*time.Hour), []byte(body)); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		at      time.Time
+		want    string
+		wantErr error
+	}{
+		{name: "latest", key: key, want: `{"v":3}`},
+		{name: "exact time", key: key, at: base.Add(time.Hour), want: `{"v":2}`},
+		{name: "between fetches", key: key, at: base.Add(90 * time.Minute), want: `{"v":2}`},
+		{name: "before first fetch", key: key, at: base.Add(-time.Minute), wantErr: ErrNotArchived},
+		{name: "unknown key", key: "/api/v2/product/43", wantErr: ErrNotArchived},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := a.Get(tt.key, tt.at)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("Get() = %s, want %s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchiveRecordReplay(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/api/v2/product/404" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"payload": {"data": {"id": 42, "title": "Product"}}}`))
+	}))
+	defer srv.Close()
+
+	archive := NewArchive(t.TempDir())
+	retry := RetryPolicy{MaxAttempts: 1}
+
+	record := NewKazanExpressClient(ClientOptions{BaseURL: srv.URL, Retry: retry, Transport: NewRecordTransport(archive, nil)})
+	if _, err := record.FetchProduct(context.Background(), 42); err != nil {
+		t.Fatalf("record FetchProduct() error = %v", err)
+	}
+	if _, err := record.FetchProduct(context.Background(), 404); err == nil {
+		t.Fatal("record FetchProduct() of missing product error = nil")
+	}
+
+	// replay against another base URL to check responses are keyed without host
+	recorded := atomic.LoadInt32(&calls)
+	replay := NewKazanExpressClient(ClientOptions{BaseURL: "http://replay.invalid", Retry: retry, Transport: NewReplayTransport(archive, time.Time{})})
+	p, err := replay.FetchProduct(context.Background(), 42)
+	if err != nil {
+		t.Fatalf("replay FetchProduct() error = %v", err)
+	}
+	if p.PortalID != 42 || p.Title != "Product" {
+		t.Errorf("replayed product = %d %q, want 42 %q", p.PortalID, p.Title, "Product")
+	}
+	if atomic.LoadInt32(&calls) != recorded {
+		t.Error("replay made requests to upstream")
+	}
+
+	// failed responses are not recorded, missing ones fail transiently without retries
+	replay = NewKazanExpressClient(ClientOptions{
+		BaseURL:   "http://replay.invalid",
+		Retry:     RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour},
+		Transport: NewReplayTransport(archive, time.Time{}),
+	})
+	for _, id := range []int64{404, 43} {
+		_, err = replay.FetchProduct(context.Background(), id)
+		if err == nil || IsPermanent(err) || !errors.Is(err, ErrNotArchived) {
+			t.Errorf("replay FetchProduct(%d) error = %v, want transient not archived", id, err)
+		}
+		if n := fetchAttempts(err); n != 1 {
+			t.Errorf("replay FetchProduct(%d) made %d attempts, want 1", id, n)
+		}
+	}
+}
+
+func TestReplayTransportBody(t *testing.T) {
+	archive := NewArchive(t.TempDir())
+	if err := archive.Put("/a?b=1", time.Now(), []byte("body")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://any/a?b=1", nil)
+	resp, err := NewReplayTransport(archive, time.Time{}).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "body" {
+		t.Errorf("RoundTrip() = %d %q, want 200 %q", resp.StatusCode, body, "body")
+	}
+}
@@ -1,19 +1,15 @@
 package service
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
-	"errors"
-	"log"
-	"net/http"
 	"time"
 
+	"github.com/isqad/kexpress/internal/logger"
 	"github.com/jackc/pgtype"
 	"github.com/jmoiron/sqlx"
 )
 
-const url = "https://api.kazanexpress.ru/api/v2/main/search/category?&categoryId=1"
-
 // Category is category of products
 type Category struct {
 	ID            int64        `json:"projectId,omitempty" db:"id"`
@@ -26,9 +22,32 @@ type Category struct {
 	CreatedAt     time.Time    `json:"-" db:"created_at"`
 	UpdatedAt     time.Time    `json:"-" db:"updated_at"`
 	History       pgtype.JSONB `json:"-" db:"history"`
-	Proceeds      int          `json:"proceeds,omitempty" db:"proceeds,omitempty"`
-	AvgPrice      int          `json:"avgPrice,omitempty" db:"avg_price,omitempty"`
-	SellsCount    int          `json:"sellsCount,omitempty" db:"sells_count,omitempty"`
+	// Sales of the category over period, see CategoryLeavesStats
+	Proceeds       float64 `json:"proceeds,omitempty" db:"proceeds"`
+	AvgPrice       float64 `json:"avgPrice,omitempty" db:"avg_price"`
+	SellsCount     float64 `json:"sellsCount,omitempty" db:"sells_count"`
+	SellersCount   int     `json:"sellersCount,omitempty" db:"sellers_count"`
+	ActiveProducts int     `json:"activeProducts,omitempty" db:"active_products"`
+}
+
+// CategoryPeriods are periods in days category stats are available for
+var CategoryPeriods = []int{7, 30, 90}
+
+// categoryStatsSort maps sort keys of category stats to columns
+var categoryStatsSort = map[string]string{
+	"title":          "title",
+	"productAmount":  "products_amount",
+	"proceeds":       "proceeds",
+	"avgPrice":       "avg_price",
+	"sellsCount":     "sells_count",
+	"sellersCount":   "sellers_count",
+	"activeProducts": "active_products",
+}
+
+// IsCategoryStatsSort reports whether category stats can be sorted by the key
+func IsCategoryStatsSort(key string) bool {
+	_, ok := categoryStatsSort[key]
+	return ok
 }
 
 // CategoryResponse is response from server
@@ -42,7 +61,7 @@ type CategoryResponsePayload struct {
 	RootCategory *Category `json:"category"`
 }
 
-func AllCategories(db *sqlx.DB) ([]*Category, error) {
+func AllCategories(ctx context.Context, db *sqlx.DB) ([]*Category, error) {
 	query := `WITH RECURSIVE t AS (
 				SELECT id,
 					   trim(both ' ' from title::text) AS title,
@@ -67,7 +86,7 @@ func AllCategories(db *sqlx.DB) ([]*Category, error) {
 			  FROM t WHERE is_leaf
 			  ORDER BY products_amount DESC`
 	leaves := []*Category{}
-	err := db.Select(&leaves, query)
+	err := db.SelectContext(ctx, &leaves, query)
 	if err != nil {
 		return nil, err
 	}
@@ -76,10 +95,10 @@ func AllCategories(db *sqlx.DB) ([]*Category, error) {
 }
 
 // RootCategories returns all root categories
-func RootCategories(db *sqlx.DB) ([]*Category, error) {
+func RootCategories(ctx context.Context, db *sqlx.DB) ([]*Category, error) {
 	query := `SELECT * FROM categories WHERE parent_id = 0 ORDER BY products_amount DESC`
 	roots := []*Category{}
-	err := db.Select(&roots, query)
+	err := db.SelectContext(ctx, &roots, query)
 	if err != nil {
 		return nil, err
 	}
@@ -87,44 +106,52 @@ func RootCategories(db *sqlx.DB) ([]*Category, error) {
 	return roots, nil
 }
 
-func findCategory(db *sqlx.DB, ID int64) (*Category, error) {
+func findCategory(ctx context.Context, db *sqlx.DB, ID int64) (*Category, error) {
 	c := &Category{}
-	if err := db.Get(c, `SELECT * FROM categories WHERE id = $1 LIMIT 1`, ID); err != nil {
+	if err := db.GetContext(ctx, c, `SELECT * FROM categories WHERE id = $1 LIMIT 1`, ID); err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
-// CategoryLeaves fetches leaves
-func CategoryLeaves(db *sqlx.DB, rootCategoryID int64) ([]*Category, error) {
-	query := `WITH RECURSIVE t AS (
-				SELECT id,
-					   trim(both ' ' from title::text) AS title,
-					   products_amount,
-					   parent_id,
-					   portal_id,
-					   NOT EXISTS (SELECT NULL FROM categories cl WHERE categories.id = cl.parent_id) is_leaf FROM categories WHERE id = $1
-				UNION ALL
-				SELECT categories.id,
-					   ((CASE WHEN t.parent_id = 0
-					     THEN ''
-						 ELSE (t.title::text || ' / ')
-						 END) || categories.title)::text AS title,
-					   categories.products_amount,
-				       categories.parent_id,
-					   categories.portal_id,
-					   NOT EXISTS (SELECT NULL FROM categories cl WHERE categories.id = cl.parent_id) is_leaf FROM t JOIN categories ON t.id = categories.parent_id
-			  )
-			  SELECT
-				id,
-				title::text,
-				products_amount,
-				portal_id
-			  FROM t WHERE is_leaf
+func findCategoryByPortalID(ctx context.Context, db *sqlx.DB, portalID int64) (*Category, error) {
+	c := &Category{}
+	if err := db.GetContext(ctx, c, `SELECT * FROM categories WHERE portal_id = $1 LIMIT 1`, portalID); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
 
-			  ORDER BY products_amount DESC`
+// leavesQuery selects leaves of the root category $1 titled with path from the root
+const leavesQuery = `WITH RECURSIVE t AS (
+			SELECT id,
+				   trim(both ' ' from title::text) AS title,
+				   products_amount,
+				   parent_id,
+				   portal_id,
+				   NOT EXISTS (SELECT NULL FROM categories cl WHERE categories.id = cl.parent_id) is_leaf FROM categories WHERE id = $1
+			UNION ALL
+			SELECT categories.id,
+				   ((CASE WHEN t.parent_id = 0
+				     THEN ''
+					 ELSE (t.title::text || ' / ')
+					 END) || categories.title)::text AS title,
+				   categories.products_amount,
+			       categories.parent_id,
+				   categories.portal_id,
+				   NOT EXISTS (SELECT NULL FROM categories cl WHERE categories.id = cl.parent_id) is_leaf FROM t JOIN categories ON t.id = categories.parent_id
+		  )
+		  SELECT
+			id,
+			title::text,
+			products_amount,
+			portal_id
+		  FROM t WHERE is_leaf`
+
+// CategoryLeaves fetches leaves
+func CategoryLeaves(ctx context.Context, db *sqlx.DB, rootCategoryID int64) ([]*Category, error) {
 	leaves := []*Category{}
-	err := db.Select(&leaves, query, rootCategoryID)
+	err := db.SelectContext(ctx, &leaves, leavesQuery+` ORDER BY products_amount DESC`, rootCategoryID)
 	if err != nil {
 		return nil, err
 	}
@@ -132,44 +159,55 @@ func CategoryLeaves(db *sqlx.DB, rootCategoryID int64) ([]*Category, error) {
 	return leaves, nil
 }
 
-func loadCategories() ([]*Category, error) {
-	client := &http.Client{
-		Timeout: 120 * time.Second,
-	}
-	req, err := newRequest(url)
-	if err != nil {
-		return nil, err
+// CategoryLeavesStats fetches leaves with their sales over last period days
+// sorted by the stats key, see IsCategoryStatsSort
+func CategoryLeavesStats(ctx context.Context, db *sqlx.DB, rootCategoryID int64, period int, sort string, desc bool) ([]*Category, error) {
+	column, ok := categoryStatsSort[sort]
+	if !ok {
+		column = "proceeds"
 	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	order := "ASC"
+	if desc {
+		order = "DESC"
 	}
 
-	defer resp.Body.Close()
-
-	r := &CategoryResponse{}
+	query := `SELECT leaves.*,
+	    COALESCE(sales.proceeds, 0) AS proceeds,
+	    COALESCE(sales.avg_price, 0) AS avg_price,
+	    COALESCE(sales.sells_count, 0) AS sells_count,
+	    COALESCE(sales.sellers_count, 0) AS sellers_count,
+	    COALESCE(active.active_products, 0) AS active_products
+	  FROM (` + leavesQuery + `) leaves
+	  LEFT JOIN LATERAL (
+	    SELECT SUM(s.revenue)::float8 AS proceeds,
+	      (SUM(s.revenue) / NULLIF(SUM(s.units), 0))::float8 AS avg_price,
+	      SUM(s.units)::float8 AS sells_count,
+	      COUNT(DISTINCT s.seller_id) FILTER (WHERE s.seller_id > 0) AS sellers_count
+	    FROM product_daily_sales s
+	    WHERE s.category_id = leaves.id AND s.day > CURRENT_DATE - $2::int
+	  ) sales ON TRUE
+	  LEFT JOIN LATERAL (
+	    SELECT COUNT(DISTINCT o.product_id) AS active_products
+	    FROM product_observations o
+	    WHERE o.category_id = leaves.id AND o.observed_at > NOW() - make_interval(days => $2::int)
+	  ) active ON TRUE
+	  ORDER BY ` + column + ` ` + order + ` NULLS LAST, id`
 
-	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(r)
-	if err != nil {
+	leaves := []*Category{}
+	if err := db.SelectContext(ctx, &leaves, query, rootCategoryID, period); err != nil {
 		return nil, err
 	}
 
-	if r.Error != "" {
-		return nil, errors.New(r.Error)
-	}
-	log.Println("Categories has been loaded")
-
-	return r.Payload.RootCategory.Children, nil
+	return leaves, nil
 }
 
-func saveCategories(db *sqlx.DB, children []*Category) error {
+func saveCategories(ctx context.Context, db *sqlx.DB, children []*Category) error {
+	l := logger.FromContext(ctx)
 	var id int64
 	for _, c := range children {
-		log.Printf("Save category: %s\n", c.Title)
+		l.Debugf("save category %s", c.Title)
 
-		if err := db.Get(&id, `INSERT INTO categories (portal_id, title, products_amount, parent_id, created_at)
+		if err := db.GetContext(ctx, &id, `INSERT INTO categories (portal_id, title, products_amount, parent_id, created_at)
 		  VALUES ($1, $2, $3, $4, NOW())
 		  ON CONFLICT ON CONSTRAINT uniq_portal_id_categories DO UPDATE
 		    SET updated_at = NOW(),
@@ -187,7 +225,7 @@ func saveCategories(db *sqlx.DB, children []*Category) error {
 			c.ParentID,
 		); err != nil {
 			if err == sql.ErrNoRows {
-				if err = db.Get(&id, `SELECT id FROM categories WHERE portal_id = $1`, c.PortalID); err != nil {
+				if err = db.GetContext(ctx, &id, `SELECT id FROM categories WHERE portal_id = $1`, c.PortalID); err != nil {
 					return err
 				}
 			} else {
@@ -196,25 +234,27 @@ func saveCategories(db *sqlx.DB, children []*Category) error {
 		}
 
 		if c.Children != nil {
-			log.Println("Category has children")
 			for _, cc := range c.Children {
 				cc.ParentID = id
 			}
-			if err := saveCategories(db, c.Children); err != nil {
+			if err := saveCategories(ctx, db, c.Children); err != nil {
 				return err
 			}
 		}
 	}
-	log.Println("Categories saved")
 
 	return nil
 }
 
-func CrawlCategories(db *sqlx.DB) error {
-	log.Println("Crawl categories")
-	c, err := loadCategories()
+// CrawlCategories loads category tree and saves it
+func CrawlCategories(ctx context.Context, db *sqlx.DB, client MarketplaceClient) error {
+	l := logger.FromContext(ctx)
+	l.Infof("crawl categories")
+	c, err := client.FetchCategories(ctx)
 	if err != nil {
 		return err
 	}
-	return saveCategories(db, c)
+	l.Infof("categories loaded")
+
+	return saveCategories(ctx, db, c)
 }
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CharFacetValue is characteristic value with products carrying it and their sales.
+// Orders and sales of product are split evenly between its values of the characteristic.
+type CharFacetValue struct {
+	CharValueID     int64   `json:"id" db:"char_value_id"`
+	Title           string  `json:"title" db:"title"`
+	Value           string  `json:"value" db:"value"`
+	ProductsCount   int     `json:"productsCount" db:"products_count"`
+	SkusCount       int     `json:"skusCount" db:"skus_count"`
+	AvailableAmount int     `json:"availableAmount" db:"available_amount"`
+	Orders          float64 `json:"orders" db:"orders"`
+	Units           float64 `json:"units" db:"units"`
+	Revenue         float64 `json:"revenue" db:"revenue"`
+}
+
+// CharFacet is distribution of characteristic values
+type CharFacet struct {
+	CharID int64             `json:"id"`
+	Title  string            `json:"title"`
+	Values []*CharFacetValue `json:"values"`
+}
+
+// charFacetSort maps sort keys of facet values to columns
+var charFacetSort = map[string]string{
+	"products": "products_count",
+	"skus":     "skus_count",
+	"stock":    "available_amount",
+	"orders":   "orders",
+	"units":    "units",
+	"revenue":  "revenue",
+}
+
+// IsCharFacetSort reports whether facet values can be sorted by the key
+func IsCharFacetSort(key string) bool {
+	_, ok := charFacetSort[key]
+	return ok
+}
+
+// CharFacetOptions are filters and sorting of characteristic facets
+type CharFacetOptions struct {
+	// CategoryID limits products to the category subtree
+	CategoryID int64
+	// Char limits facets to characteristic with the title, all characteristics if empty
+	Char string
+	// Period is number of last days sales are summed up for
+	Period int
+	Sort   string
+	// Limit is number of values per characteristic, all values if zero
+	Limit int
+}
+
+// CharFacets returns distributions of characteristic values of parsed products in category with their orders and estimated sales
+func CharFacets(ctx context.Context, db *sqlx.DB, opts CharFacetOptions) ([]*CharFacet, error) {
+	column, ok := charFacetSort[opts.Sort]
+	if !ok {
+		column = "products_count"
+	}
+
+	query := `WITH RECURSIVE subtree AS (
+	    SELECT id FROM categories WHERE id = $1
+	    UNION ALL
+	    SELECT categories.id FROM subtree JOIN categories ON categories.parent_id = subtree.id
+	  ), parsed_products AS (
+	    SELECT id, portal_id, orders_amount
+	    FROM products
+	    WHERE parsed_at IS NOT NULL AND category_id IN (SELECT id FROM subtree)
+	  ), product_values AS (
+	    SELECT lp.portal_id, lp.orders_amount, cv.char_id, cv.id AS char_value_id,
+	      COUNT(*) AS skus_count, SUM(skus.available_amount) AS available_amount
+	    FROM parsed_products lp
+	    JOIN skus ON skus.product_id = lp.id
+	    JOIN sku_char_values scv ON scv.sku_id = skus.id
+	    JOIN char_values cv ON cv.id = scv.char_value_id
+	    JOIN characteristics c ON c.id = cv.char_id
+	    WHERE $2 = '' OR c.title = $2
+	    GROUP BY lp.portal_id, lp.orders_amount, cv.char_id, cv.id
+	  ), shares AS (
+	    SELECT pv.*, COUNT(*) OVER (PARTITION BY pv.portal_id, pv.char_id) AS values_count
+	    FROM product_values pv
+	  ), sales AS (
+	    SELECT product_portal_id, SUM(units) AS units, SUM(revenue) AS revenue
+	    FROM product_daily_sales
+	    WHERE day > CURRENT_DATE - $3::int AND category_id IN (SELECT id FROM subtree)
+	    GROUP BY product_portal_id
+	  )
+	  SELECT * FROM (
+	    SELECT value_stats.*, ROW_NUMBER() OVER (PARTITION BY char_id ORDER BY ` + column + ` DESC, char_value_id) AS rank
+	    FROM (
+	      SELECT c.id AS char_id, c.title AS char_title,
+	        cv.id AS char_value_id, cv.title, cv.value,
+	        COUNT(*) AS products_count,
+	        SUM(s.skus_count) AS skus_count,
+	        SUM(s.available_amount) AS available_amount,
+	        SUM(s.orders_amount::float8 / s.values_count) AS orders,
+	        COALESCE(SUM(sales.units / s.values_count), 0)::float8 AS units,
+	        COALESCE(SUM(sales.revenue / s.values_count), 0)::float8 AS revenue
+	      FROM shares s
+	      JOIN char_values cv ON cv.id = s.char_value_id
+	      JOIN characteristics c ON c.id = cv.char_id
+	      LEFT JOIN sales ON sales.product_portal_id = s.portal_id
+	      GROUP BY c.id, c.title, cv.id, cv.title, cv.value
+	    ) value_stats
+	  ) facets
+	  WHERE $4 = 0 OR rank <= $4
+	  ORDER BY char_title, rank`
+
+	rows := []struct {
+		CharID    int64  `db:"char_id"`
+		CharTitle string `db:"char_title"`
+		Rank      int    `db:"rank"`
+		CharFacetValue
+	}{}
+	if err := db.SelectContext(ctx, &rows, query, opts.CategoryID, opts.Char, opts.Period, opts.Limit); err != nil {
+		return nil, err
+	}
+
+	facets := []*CharFacet{}
+	var f *CharFacet
+	for i := range rows {
+		r := &rows[i]
+		if f == nil || f.CharID != r.CharID {
+			f = &CharFacet{CharID: r.CharID, Title: r.CharTitle, Values: []*CharFacetValue{}}
+			facets = append(facets, f)
+		}
+		f.Values = append(f.Values, &r.CharFacetValue)
+	}
+
+	return facets, nil
+}
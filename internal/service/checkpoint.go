@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// checkpoint is progress of the crawl stage in category within session
+type checkpoint struct {
+	SessionID  int64  `db:"session_id"`
+	Stage      string `db:"stage"`
+	CategoryID int64  `db:"category_id"`
+	LastPage   int    `db:"last_page"`
+	// LastProductID is ID of the last processed product observation
+	LastProductID int64 `db:"last_product_id"`
+	Done          bool  `db:"done"`
+}
+
+func newCheckpoint(sessionID int64, stage string, categoryID int64) *checkpoint {
+	return &checkpoint{
+		SessionID:  sessionID,
+		Stage:      stage,
+		CategoryID: categoryID,
+		LastPage:   -1,
+	}
+}
+
+// loadCheckpoints returns checkpoints of the stage by category ID
+func loadCheckpoints(ctx context.Context, db *sqlx.DB, sessionID int64, stage string) (map[int64]*checkpoint, error) {
+	cps := []*checkpoint{}
+	if err := db.SelectContext(ctx, &cps, `SELECT session_id, stage, category_id, last_page, last_product_id, done
+	  FROM crawl_checkpoints WHERE session_id = $1 AND stage = $2`, sessionID, stage); err != nil {
+		return nil, err
+	}
+
+	byCategory := make(map[int64]*checkpoint, len(cps))
+	for _, cp := range cps {
+		byCategory[cp.CategoryID] = cp
+	}
+
+	return byCategory, nil
+}
+
+// save stores checkpoint, checkpoints out of session are not stored
+func (cp *checkpoint) save(ctx context.Context, db *sqlx.DB) error {
+	if cp.SessionID == 0 {
+		return nil
+	}
+
+	_, err := db.NamedExecContext(ctx, `INSERT INTO crawl_checkpoints
+	  (session_id, stage, category_id, last_page, last_product_id, done, updated_at)
+	  VALUES (:session_id, :stage, :category_id, :last_page, :last_product_id, :done, NOW())
+	  ON CONFLICT (session_id, stage, category_id) DO UPDATE SET
+	    last_page = EXCLUDED.last_page,
+	    last_product_id = EXCLUDED.last_product_id,
+	    done = EXCLUDED.done,
+	    updated_at = NOW()`, cp)
+	return err
+}
+
+// resumableSession returns session of the last run of the job stage
+// if the run has not succeeded today, zero otherwise
+func resumableSession(ctx context.Context, db *sqlx.DB, job string, rootCategoryID int64, stage string) (int64, error) {
+	var sessionID int64
+	err := db.GetContext(ctx, &sessionID, `SELECT session_id FROM (
+	    SELECT session_id, status, started_at FROM crawl_runs
+	    WHERE job = $1 AND root_category_id = $2 AND stage = $3
+	    ORDER BY started_at DESC LIMIT 1
+	  ) last_run
+	  WHERE status != $4 AND session_id > 0 AND started_at > NOW() - interval '1 day'`,
+		job, rootCategoryID, stage, RunSucceeded)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return sessionID, err
+}
+
+// lastListingSession returns session of the last listing crawl of the job root category
+func lastListingSession(ctx context.Context, db *sqlx.DB, job string, rootCategoryID int64) (int64, error) {
+	var sessionID int64
+	err := db.GetContext(ctx, &sessionID, `SELECT session_id FROM crawl_runs
+	  WHERE job = $1 AND root_category_id = $2 AND stage = $3
+	  ORDER BY started_at DESC LIMIT 1`, job, rootCategoryID, StageListing)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return sessionID, err
+}
+
+// watermark tracks the highest observation ID such that all observations
+// up to it in order of dispatch are processed
+type watermark struct {
+	mu      sync.Mutex
+	pending []int64
+	done    map[int64]bool
+	mark    int64
+}
+
+func newWatermark(mark int64) *watermark {
+	return &watermark{done: make(map[int64]bool), mark: mark}
+}
+
+// dispatched must be called in increasing order of IDs
+func (w *watermark) dispatched(id int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, id)
+}
+
+// processed marks ID as processed and reports whether the mark moved
+func (w *watermark) processed(id int64) (int64, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.done[id] = true
+	moved := false
+	for len(w.pending) > 0 && w.done[w.pending[0]] {
+		delete(w.done, w.pending[0])
+		w.mark = w.pending[0]
+		w.pending = w.pending[1:]
+		moved = true
+	}
+
+	return w.mark, moved
+}
+
+// complete reports whether all dispatched IDs are processed
+func (w *watermark) complete() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending) == 0
+}
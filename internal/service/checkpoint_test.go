@@ -0,0 +1,101 @@
+package service
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestWatermark(t *testing.T) {
+	tests := []struct {
+		name       string
+		start      int64
+		dispatched []int64
+		processed  []int64
+		wantMarks  []int64
+		complete   bool
+	}{
+		{
+			name:       "in order",
+			dispatched: []int64{1, 2, 3},
+			processed:  []int64{1, 2, 3},
+			wantMarks:  []int64{1, 2, 3},
+			complete:   true,
+		},
+		{
+			name:       "out of order waits for gap",
+			start:      10,
+			dispatched: []int64{11, 12, 13},
+			processed:  []int64{13, 12, 11},
+			wantMarks:  []int64{10, 10, 13},
+			complete:   true,
+		},
+		{
+			name:       "sparse IDs",
+			dispatched: []int64{5, 40, 41, 90},
+			processed:  []int64{40, 5, 90, 41},
+			wantMarks:  []int64{0, 40, 40, 90},
+			complete:   true,
+		},
+		{
+			// skipped observations are dispatched but never processed
+			name:       "stuck at skipped",
+			dispatched: []int64{1, 2, 3, 4},
+			processed:  []int64{1, 3, 4},
+			wantMarks:  []int64{1, 1, 1},
+			complete:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWatermark(tt.start)
+			for _, id := range tt.dispatched {
+				w.dispatched(id)
+			}
+			prev := tt.start
+			for i, id := range tt.processed {
+				mark, moved := w.processed(id)
+				if mark != tt.wantMarks[i] {
+					t.Errorf("processed(%d) mark = %d, want %d", id, mark, tt.wantMarks[i])
+				}
+				if moved != (mark != prev) {
+					t.Errorf("processed(%d) moved = %t, mark %d -> %d", id, moved, prev, mark)
+				}
+				prev = mark
+			}
+			if w.complete() != tt.complete {
+				t.Errorf("complete() = %t, want %t", w.complete(), tt.complete)
+			}
+		})
+	}
+}
+
+func TestWatermarkConcurrent(t *testing.T) {
+	const n = 1000
+	w := newWatermark(0)
+	ids := make(chan int64, n)
+	for id := int64(1); id <= n; id++ {
+		w.dispatched(id)
+		ids <- id
+	}
+	close(ids)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				w.processed(id)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if w.mark != n {
+		t.Errorf("mark = %d, want %d", w.mark, n)
+	}
+	if !w.complete() {
+		t.Error("complete() = false after all IDs processed")
+	}
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isqad/kexpress/internal/logger"
+)
+
+// DefaultBaseURL is base URL of the KazanExpress API
+const DefaultBaseURL = "https://api.kazanexpress.ru"
+
+// MarketplaceClient fetches data from the marketplace API
+type MarketplaceClient interface {
+	// FetchCategories returns top level categories with their children
+	FetchCategories(ctx context.Context) ([]*Category, error)
+	// FetchProductList returns one page of the category listing
+	FetchProductList(ctx context.Context, portalCategoryID int64, page int) (*ProductListResponse, error)
+	// FetchProduct returns product card
+	FetchProduct(ctx context.Context, portalID int64) (*Product, error)
+}
+
+// ClientOptions configures KazanExpressClient
+type ClientOptions struct {
+	// BaseURL of the API, DefaultBaseURL if empty
+	BaseURL string
+	// Limiter shared by all requests, nil means no limits
+	Limiter *Limiter
+	// Retry policy, DefaultRetryPolicy if MaxAttempts is not set
+	Retry RetryPolicy
+	// Transport used for requests, http.DefaultTransport if nil
+	Transport http.RoundTripper
+}
+
+// KazanExpressClient is MarketplaceClient for the KazanExpress API
+type KazanExpressClient struct {
+	baseURL string
+	client  *http.Client
+	limiter *Limiter
+	retry   RetryPolicy
+}
+
+// NewKazanExpressClient creates client for the KazanExpress API
+func NewKazanExpressClient(opts ClientOptions) *KazanExpressClient {
+	if opts.BaseURL == "" {
+		opts.BaseURL = DefaultBaseURL
+	}
+	if opts.Retry.MaxAttempts <= 0 {
+		opts.Retry = DefaultRetryPolicy
+	}
+
+	return &KazanExpressClient{
+		baseURL: strings.TrimRight(opts.BaseURL, "/"),
+		client: &http.Client{
+			Timeout:   120 * time.Second,
+			Transport: opts.Transport,
+		},
+		limiter: opts.Limiter,
+		retry:   opts.Retry,
+	}
+}
+
+// FetchCategories implements MarketplaceClient
+func (c *KazanExpressClient) FetchCategories(ctx context.Context) ([]*Category, error) {
+	path := "/api/v2/main/search/category?&categoryId=1"
+
+	r := &CategoryResponse{}
+	if err := c.get(ctx, endpointCategories, path, r); err != nil {
+		return nil, err
+	}
+
+	if r.Error != "" {
+		return nil, c.apiError(path, r.Error)
+	}
+	if r.Payload == nil || r.Payload.RootCategory == nil {
+		return nil, c.apiError(path, "response has no category tree")
+	}
+
+	return r.Payload.RootCategory.Children, nil
+}
+
+// FetchProductList implements MarketplaceClient
+func (c *KazanExpressClient) FetchProductList(ctx context.Context, portalCategoryID int64, page int) (*ProductListResponse, error) {
+	path := fmt.Sprintf("/api/v2/main/search/product?size=%d&page=%d&categoryId=%d&sortBy=orders&order=descending",
+		perPage, page, portalCategoryID)
+
+	r := &ProductListResponse{}
+	if err := c.get(ctx, endpointProductList, path, r); err != nil {
+		return nil, err
+	}
+
+	if r.Error != "" {
+		return nil, c.apiError(path, r.Error)
+	}
+
+	return r, nil
+}
+
+// FetchProduct implements MarketplaceClient
+func (c *KazanExpressClient) FetchProduct(ctx context.Context, portalID int64) (*Product, error) {
+	path := fmt.Sprintf("/api/v2/product/%d", portalID)
+
+	r := &ProductResponse{}
+	if err := c.get(ctx, endpointProduct, path, r); err != nil {
+		return nil, err
+	}
+
+	if r.Error != "" {
+		return nil, c.apiError(path, r.Error)
+	}
+	if r.Payload.Data == nil {
+		return nil, c.apiError(path, "response has no product card")
+	}
+
+	return r.Payload.Data, nil
+}
+
+// apiError is error reported by API in the response body, e.g. "not found",
+// or successful response missing the requested data. Such errors are not retried.
+func (c *KazanExpressClient) apiError(path string, msg string) error {
+	return &FetchError{
+		URL:       c.baseURL + path,
+		Attempts:  1,
+		Permanent: true,
+		Err:       errors.New(msg),
+	}
+}
+
+// get fetches path and decodes response into v retrying on transient errors.
+// Retries stop when ctx is done.
+func (c *KazanExpressClient) get(ctx context.Context, endpoint string, path string, v interface{}) error {
+	url := c.baseURL + path
+
+	var fe *FetchError
+	for attempt := 0; attempt < c.retry.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			delay := c.retry.delay(attempt-1, fe.RetryAfter)
+			logger.FromContext(ctx).With("error", fe).Warnf("retry %s in %s", endpoint, delay)
+			if err := sleep(ctx, delay); err != nil {
+				return &FetchError{URL: url, Attempts: attempt, Err: err}
+			}
+		}
+
+		fe = c.do(ctx, endpoint, url, v)
+		if fe == nil {
+			return nil
+		}
+		fe.Attempts = attempt + 1
+		// replayed archive misses the response on every attempt
+		if fe.Permanent || errors.Is(fe, ErrNotArchived) || ctx.Err() != nil {
+			break
+		}
+	}
+
+	return fe
+}
+
+func (c *KazanExpressClient) do(ctx context.Context, endpoint string, url string, v interface{}) *FetchError {
+	req, err := newRequest(ctx, url)
+	if err != nil {
+		return &FetchError{URL: url, Permanent: true, Err: err}
+	}
+
+	release, err := c.limiter.Acquire(ctx)
+	if err != nil {
+		return &FetchError{URL: url, Err: err}
+	}
+	defer release()
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	upstreamDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if err != nil {
+		upstreamRequests.WithLabelValues(endpoint, "error").Inc()
+		return &FetchError{URL: url, Err: err}
+	}
+	defer resp.Body.Close()
+	upstreamRequests.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+
+	if fe := classifyResponse(resp); fe != nil {
+		return fe
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return &FetchError{URL: url, StatusCode: resp.StatusCode, Err: err}
+	}
+
+	return nil
+}
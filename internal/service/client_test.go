@@ -0,0 +1,232 @@
+package service_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/isqad/kexpress/internal/service"
+	"github.com/isqad/kexpress/internal/testserver"
+)
+
+// stubResponses are canned API responses keyed by request path
+var stubResponses = map[string]string{
+	"/api/v2/main/search/category": `{"payload":{"category":{"id":1,"title":"Все","children":[
+		{"id":10,"title":"Одежда","productAmount":2,"children":[]}]}}}`,
+	"/api/v2/main/search/product": `{"payload":{"totalProducts":2,"products":[
+		{"productId":100,"title":"Футболка","categoryId":10},
+		{"productId":101,"title":"Шорты","categoryId":10}]}}`,
+	"/api/v2/product/100": `{"payload":{"data":{"id":100,"title":"Футболка","seller":{"id":7,"title":"Магазин"}}}}`,
+	"/api/v2/product/404": `{"error":"product not found"}`,
+}
+
+// newStubClient returns client of the server answering with stubResponses
+func newStubClient(t *testing.T) service.MarketplaceClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := stubResponses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	return service.NewKazanExpressClient(service.ClientOptions{BaseURL: srv.URL + "/"})
+}
+
+func TestStubClient(t *testing.T) {
+	client := newStubClient(t)
+
+	categories, err := client.FetchCategories(context.Background())
+	if err != nil {
+		t.Fatalf("FetchCategories() error = %v", err)
+	}
+	if len(categories) != 1 || categories[0].PortalID != 10 || categories[0].ProductAmount != 2 {
+		t.Fatalf("FetchCategories() = %+v, want one category 10 with 2 products", categories)
+	}
+
+	list, err := client.FetchProductList(context.Background(), 10, 0)
+	if err != nil {
+		t.Fatalf("FetchProductList() error = %v", err)
+	}
+	if len(list.Payload.Products) != 2 || list.Payload.Products[1].PortalID != 101 {
+		t.Errorf("FetchProductList() returned %+v, want products 100 and 101", list.Payload.Products)
+	}
+
+	p, err := client.FetchProduct(context.Background(), 100)
+	if err != nil {
+		t.Fatalf("FetchProduct() error = %v", err)
+	}
+	if p.PortalID != 100 || p.Seller == nil || p.Seller.PortalID != 7 {
+		t.Errorf("FetchProduct() = %+v, want product 100 of seller 7", p)
+	}
+
+	if _, err := client.FetchProduct(context.Background(), 404); err == nil {
+		t.Error("FetchProduct() of unknown product error = nil, want API error")
+	}
+}
+
+func TestClientEmptyPayload(t *testing.T) {
+	for _, body := range []string{`{"payload":null}`, `{"payload":{}}`, `{}`} {
+		t.Run(body, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(body))
+			}))
+			defer srv.Close()
+			client := service.NewKazanExpressClient(service.ClientOptions{BaseURL: srv.URL})
+
+			if _, err := client.FetchCategories(context.Background()); !service.IsPermanent(err) {
+				t.Errorf("FetchCategories() error = %v, want permanent", err)
+			}
+			if _, err := client.FetchProduct(context.Background(), 100); !service.IsPermanent(err) {
+				t.Errorf("FetchProduct() error = %v, want permanent", err)
+			}
+		})
+	}
+}
+
+// newFakeClient returns client of the fake API serving catalog generated from opts
+func newFakeClient(t *testing.T, opts testserver.Options) service.MarketplaceClient {
+	t.Helper()
+	srv := httptest.NewServer(testserver.New(opts).Handler())
+	t.Cleanup(srv.Close)
+
+	return service.NewKazanExpressClient(service.ClientOptions{
+		BaseURL: srv.URL,
+		Retry:   service.RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+	})
+}
+
+// leaves returns leaf categories of the tree
+func leaves(categories []*service.Category) []*service.Category {
+	found := []*service.Category{}
+	for _, c := range categories {
+		if len(c.Children) == 0 {
+			found = append(found, c)
+			continue
+		}
+		found = append(found, leaves(c.Children)...)
+	}
+	return found
+}
+
+func TestClientFetchCategories(t *testing.T) {
+	opts := testserver.Options{Seed: 1, Roots: 2, Children: 3, Depth: 2, Products: 5, Sellers: 3}
+	client := newFakeClient(t, opts)
+
+	categories, err := client.FetchCategories(context.Background())
+	if err != nil {
+		t.Fatalf("FetchCategories() error = %v", err)
+	}
+	if len(categories) != opts.Roots {
+		t.Fatalf("FetchCategories() returned %d roots, want %d", len(categories), opts.Roots)
+	}
+
+	// every root has Children^Depth leaves
+	wantLeaves := opts.Roots * opts.Children * opts.Children
+	if got := len(leaves(categories)); got != wantLeaves {
+		t.Errorf("got %d leaves, want %d", got, wantLeaves)
+	}
+	for _, root := range categories {
+		if want := opts.Children * opts.Children * opts.Products; root.ProductAmount != want {
+			t.Errorf("root %d has %d products, want %d", root.PortalID, root.ProductAmount, want)
+		}
+	}
+}
+
+func TestClientFetchProductList(t *testing.T) {
+	opts := testserver.Options{Seed: 1, Roots: 1, Children: 1, Depth: 1, Products: 30, Sellers: 3}
+	client := newFakeClient(t, opts)
+
+	categories, err := client.FetchCategories(context.Background())
+	if err != nil {
+		t.Fatalf("FetchCategories() error = %v", err)
+	}
+	leaf := leaves(categories)[0]
+
+	seen := map[int64]bool{}
+	for page := 0; ; page++ {
+		r, err := client.FetchProductList(context.Background(), leaf.PortalID, page)
+		if err != nil {
+			t.Fatalf("FetchProductList(page %d) error = %v", page, err)
+		}
+		if r.Payload.TotalProducts != opts.Products {
+			t.Errorf("TotalProducts = %d, want %d", r.Payload.TotalProducts, opts.Products)
+		}
+		if len(r.Payload.Products) == 0 {
+			break
+		}
+		for _, p := range r.Payload.Products {
+			if seen[p.PortalID] {
+				t.Errorf("product %d is listed twice", p.PortalID)
+			}
+			seen[p.PortalID] = true
+			if p.PortalCategoryID != leaf.PortalID {
+				t.Errorf("product %d category = %d, want %d", p.PortalID, p.PortalCategoryID, leaf.PortalID)
+			}
+		}
+	}
+	if len(seen) != opts.Products {
+		t.Errorf("listed %d products, want %d", len(seen), opts.Products)
+	}
+
+	if _, err := client.FetchProductList(context.Background(), 999999, 0); !service.IsPermanent(err) {
+		t.Errorf("FetchProductList() of unknown category error = %v, want permanent", err)
+	}
+}
+
+func TestClientFetchProduct(t *testing.T) {
+	opts := testserver.Options{Seed: 1, Roots: 1, Children: 1, Depth: 1, Products: 3, Sellers: 2}
+	client := newFakeClient(t, opts)
+
+	categories, err := client.FetchCategories(context.Background())
+	if err != nil {
+		t.Fatalf("FetchCategories() error = %v", err)
+	}
+	list, err := client.FetchProductList(context.Background(), leaves(categories)[0].PortalID, 0)
+	if err != nil {
+		t.Fatalf("FetchProductList() error = %v", err)
+	}
+
+	for _, item := range list.Payload.Products {
+		p, err := client.FetchProduct(context.Background(), item.PortalID)
+		if err != nil {
+			t.Fatalf("FetchProduct(%d) error = %v", item.PortalID, err)
+		}
+		if p.PortalID != item.PortalID || p.Title != item.Title {
+			t.Errorf("FetchProduct(%d) = %d %q, want %d %q", item.PortalID, p.PortalID, p.Title, item.PortalID, item.Title)
+		}
+		if len(p.SkuList) == 0 {
+			t.Errorf("product %d has no SKUs", p.PortalID)
+		}
+		if p.Seller == nil || p.Seller.PortalID == 0 {
+			t.Errorf("product %d has no seller", p.PortalID)
+		}
+	}
+
+	if _, err := client.FetchProduct(context.Background(), 1); !service.IsPermanent(err) {
+		t.Errorf("FetchProduct() of unknown product error = %v, want permanent", err)
+	}
+}
+
+func TestClientRetriesFaults(t *testing.T) {
+	opts := testserver.Options{Seed: 1, Roots: 1, Children: 1, Depth: 1, Products: 3, Sellers: 2, ErrorRate: 0.3, RateLimitRate: 0.1}
+	srv := httptest.NewServer(testserver.New(opts).Handler())
+	defer srv.Close()
+
+	// Retry-After of rate limited responses is 1s, so attempts are limited by time
+	client := service.NewKazanExpressClient(service.ClientOptions{
+		BaseURL: srv.URL,
+		Retry:   service.RetryPolicy{MaxAttempts: 20, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	})
+	for i := 0; i < 5; i++ {
+		if _, err := client.FetchCategories(context.Background()); err != nil {
+			t.Fatalf("FetchCategories() error = %v", err)
+		}
+	}
+}
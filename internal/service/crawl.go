@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/isqad/kexpress/internal/logger"
+	"github.com/jmoiron/sqlx"
+)
+
+// Crawl stages
+const (
+	StageCategories = "categories"
+	StageListing    = "listing"
+	StageProducts   = "products"
+	StageSales      = "sales"
+)
+
+// Stages lists all crawl stages in order of execution
+var Stages = []string{StageCategories, StageListing, StageProducts, StageSales}
+
+// SalesWindow is number of last days sales stage estimates
+const SalesWindow = 7
+
+const defaultWorkers = 100
+
+// writeTimeout bounds writes recording crawl progress after the crawl context is done
+const writeTimeout = 30 * time.Second
+
+// detachedContext returns context of ctx logger that is not canceled with ctx.
+// It is used for writes that must survive shutdown, e.g. recording fetched card
+// or releasing claims, so they are bounded by writeTimeout instead.
+func detachedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(logger.NewContext(context.Background(), logger.FromContext(ctx)), writeTimeout)
+}
+
+// categoryFailures collects categories failed in the crawl stage, so the run
+// is recorded as failed even though the rest of categories are crawled
+type categoryFailures struct {
+	mu    sync.Mutex
+	ids   []int64
+	first error
+}
+
+func (f *categoryFailures) add(categoryID int64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.first == nil {
+		f.first = err
+	}
+	f.ids = append(f.ids, categoryID)
+}
+
+// err returns error of the failed categories, nil if none failed
+func (f *categoryFailures) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.ids) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d categories failed %v, first: %w", len(f.ids), f.ids, f.first)
+}
+
+// CrawlOptions configures crawl of root category
+type CrawlOptions struct {
+	// Job is name of the job the crawl is recorded in crawl_runs under
+	Job string
+	// Workers is number of categories crawled concurrently
+	Workers int
+	// Categories restricts crawl to the given leaf categories of the root
+	Categories []int64
+	// SessionID continues the given session
+	SessionID int64
+	// Resume continues the last session of the job if it is not finished
+	Resume bool
+}
+
+func (o CrawlOptions) job() string {
+	if o.Job == "" {
+		return "manual"
+	}
+	return o.Job
+}
+
+func (o CrawlOptions) workers() int {
+	if o.Workers <= 0 {
+		return defaultWorkers
+	}
+	return o.Workers
+}
+
+// session returns ID of the session to crawl and whether the stage continues
+// from its checkpoints. Listing starts a new session unless resumed,
+// products stage parses the last listing session of the job and resumes
+// only its own run of that session. It fails if the job has no listing.
+func (o CrawlOptions) session(ctx context.Context, db *sqlx.DB, rootCategoryID int64, stage string) (int64, bool, error) {
+	if o.SessionID != 0 {
+		return o.SessionID, true, nil
+	}
+
+	var listingSessionID int64
+	if stage != StageListing {
+		var err error
+		listingSessionID, err = lastListingSession(ctx, db, o.job(), rootCategoryID)
+		if err != nil {
+			return 0, false, err
+		}
+		if listingSessionID == 0 {
+			return 0, false, fmt.Errorf("job %s has no listing of root %d, run listing of the job first", o.job(), rootCategoryID)
+		}
+	}
+
+	if o.Resume {
+		sessionID, err := resumableSession(ctx, db, o.job(), rootCategoryID, stage)
+		if err != nil {
+			return 0, false, err
+		}
+		l := logger.FromContext(ctx).With("job", o.job(), "root_category_id", rootCategoryID, "stage", stage, "session_id", sessionID)
+		switch {
+		case sessionID == 0:
+		case stage != StageListing && sessionID != listingSessionID:
+			// newer listing has run since, checkpoints of the old session
+			// would skip categories with new observations
+			l.Infof("session is superseded by listing session %d, start over", listingSessionID)
+		default:
+			l.Infof("resume session")
+			return sessionID, true, nil
+		}
+	}
+
+	if stage == StageListing {
+		return time.Now().UnixNano(), false, nil
+	}
+
+	return listingSessionID, false, nil
+}
+
+// stageCheckpoints returns checkpoints of the stage if it is resumed
+func stageCheckpoints(ctx context.Context, db *sqlx.DB, sessionID int64, stage string, resumed bool) (map[int64]*checkpoint, error) {
+	if !resumed {
+		return map[int64]*checkpoint{}, nil
+	}
+	return loadCheckpoints(ctx, db, sessionID, stage)
+}
+
+// leaves returns leaf categories of the root to crawl
+func (o CrawlOptions) leaves(ctx context.Context, db *sqlx.DB, rootCategoryID int64) ([]*Category, error) {
+	leaves, err := CategoryLeaves(ctx, db, rootCategoryID)
+	if err != nil || len(o.Categories) == 0 {
+		return leaves, err
+	}
+
+	filtered := []*Category{}
+	for _, c := range leaves {
+		for _, id := range o.Categories {
+			if c.ID == id {
+				filtered = append(filtered, c)
+				break
+			}
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("categories %v are not leaves of root %d", o.Categories, rootCategoryID)
+	}
+
+	return filtered, nil
+}
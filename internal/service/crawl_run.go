@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/isqad/kexpress/internal/logger"
+	"github.com/jmoiron/sqlx"
+)
+
+// Statuses of crawl run
+const (
+	RunRunning     = "running"
+	RunSucceeded   = "succeeded"
+	RunFailed      = "failed"
+	RunInterrupted = "interrupted"
+)
+
+const runFlushInterval = 10 * time.Second
+
+// activeRuns are runs of this process not finished yet by ID
+var activeRuns = struct {
+	sync.Mutex
+	runs map[int64]*CrawlRun
+}{runs: map[int64]*CrawlRun{}}
+
+// CrawlRun is single run of crawl stage for root category
+type CrawlRun struct {
+	// counters go first to be 64-bit aligned for atomic operations
+	PagesFetched       int64 `json:"pagesFetched" db:"pages_fetched"`
+	PagesFailed        int64 `json:"pagesFailed" db:"pages_failed"`
+	ProductsDiscovered int64 `json:"productsDiscovered" db:"products_discovered"`
+	ProductsParsed     int64 `json:"productsParsed" db:"products_parsed"`
+	ProductsUnchanged  int64 `json:"productsUnchanged" db:"products_unchanged"`
+	ProductsFailed     int64 `json:"productsFailed" db:"products_failed"`
+
+	ID             int64      `json:"id" db:"id"`
+	Job            string     `json:"job" db:"job"`
+	RootCategoryID int64      `json:"rootCategoryId" db:"root_category_id"`
+	Stage          string     `json:"stage" db:"stage"`
+	SessionID      int64      `json:"sessionId" db:"session_id"`
+	Status         string     `json:"status" db:"status"`
+	Error          *string    `json:"error" db:"error"`
+	StartedAt      time.Time  `json:"startedAt" db:"started_at"`
+	FinishedAt     *time.Time `json:"finishedAt" db:"finished_at"`
+
+	db   *sqlx.DB
+	log  *logger.Logger
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startCrawlRun records start of the stage and flushes counters of the run
+// to database periodically until the run is finished.
+// Logger of the run adds run fields to the logger of ctx.
+func startCrawlRun(ctx context.Context, db *sqlx.DB, job string, stage string, rootCategoryID int64, sessionID int64) (*CrawlRun, error) {
+	r := &CrawlRun{
+		Job:            job,
+		RootCategoryID: rootCategoryID,
+		Stage:          stage,
+		SessionID:      sessionID,
+		Status:         RunRunning,
+		db:             db,
+		done:           make(chan struct{}),
+	}
+
+	if err := db.Get(r, `INSERT INTO crawl_runs (job, root_category_id, stage, session_id, status, started_at)
+	  VALUES ($1, $2, $3, $4, $5, NOW()) RETURNING id, started_at`,
+		r.Job, r.RootCategoryID, r.Stage, r.SessionID, r.Status); err != nil {
+		return nil, err
+	}
+	activeRuns.Lock()
+	activeRuns.runs[r.ID] = r
+	activeRuns.Unlock()
+
+	r.log = logger.FromContext(ctx).With(
+		"job", r.Job, "run_id", r.ID, "stage", r.Stage, "root_category_id", r.RootCategoryID, "session_id", r.SessionID)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(runFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := r.flush(); err != nil {
+					r.log.With("error", err).Errorf("flush crawl run")
+				}
+			case <-r.done:
+				return
+			}
+		}
+	}()
+
+	return r, nil
+}
+
+func (r *CrawlRun) pageFetched(discovered int64) {
+	if r == nil {
+		return
+	}
+	atomic.AddInt64(&r.PagesFetched, 1)
+	atomic.AddInt64(&r.ProductsDiscovered, discovered)
+	crawlPages.With(r.runLabels("fetched")).Inc()
+	crawlProducts.With(r.runLabels("discovered")).Add(float64(discovered))
+}
+
+func (r *CrawlRun) pageFailed() {
+	if r == nil {
+		return
+	}
+	atomic.AddInt64(&r.PagesFailed, 1)
+	crawlPages.With(r.runLabels("failed")).Inc()
+}
+
+func (r *CrawlRun) productParsed() {
+	if r == nil {
+		return
+	}
+	atomic.AddInt64(&r.ProductsParsed, 1)
+	crawlProducts.With(r.runLabels("parsed")).Inc()
+}
+
+func (r *CrawlRun) productUnchanged() {
+	if r == nil {
+		return
+	}
+	atomic.AddInt64(&r.ProductsUnchanged, 1)
+	crawlProducts.With(r.runLabels("unchanged")).Inc()
+}
+
+func (r *CrawlRun) productFailed() {
+	if r == nil {
+		return
+	}
+	atomic.AddInt64(&r.ProductsFailed, 1)
+	crawlProducts.With(r.runLabels("failed")).Inc()
+}
+
+func (r *CrawlRun) flush() error {
+	_, err := r.db.Exec(`UPDATE crawl_runs SET
+	  pages_fetched = $2,
+	  pages_failed = $3,
+	  products_discovered = $4,
+	  products_parsed = $5,
+	  products_unchanged = $6,
+	  products_failed = $7 WHERE id = $1`,
+		r.ID,
+		atomic.LoadInt64(&r.PagesFetched),
+		atomic.LoadInt64(&r.PagesFailed),
+		atomic.LoadInt64(&r.ProductsDiscovered),
+		atomic.LoadInt64(&r.ProductsParsed),
+		atomic.LoadInt64(&r.ProductsUnchanged),
+		atomic.LoadInt64(&r.ProductsFailed),
+	)
+	return err
+}
+
+// context returns ctx logging with fields of the run
+func (r *CrawlRun) context(ctx context.Context) context.Context {
+	return logger.NewContext(ctx, r.log)
+}
+
+// finish records the final counters and status of the run.
+// Runs stopped by canceled context are recorded as interrupted and can be resumed.
+// Run is recorded regardless of the crawl context, so it doesn't take one.
+func (r *CrawlRun) finish(runErr error) error {
+	close(r.done)
+	r.wg.Wait()
+
+	activeRuns.Lock()
+	delete(activeRuns.runs, r.ID)
+	activeRuns.Unlock()
+
+	if err := r.flush(); err != nil {
+		return err
+	}
+
+	switch {
+	case runErr == nil:
+		r.Status = RunSucceeded
+	case errors.Is(runErr, context.Canceled):
+		r.Status = RunInterrupted
+	default:
+		r.Status = RunFailed
+	}
+	if runErr != nil {
+		msg := runErr.Error()
+		r.Error = &msg
+	}
+	r.observeLastRun()
+
+	now := time.Now()
+	r.FinishedAt = &now
+	_, err := r.db.Exec(`UPDATE crawl_runs SET status = $2, error = $3, finished_at = $4 WHERE id = $1`,
+		r.ID, r.Status, r.Error, r.FinishedAt)
+	return err
+}
+
+// RunningCrawlRuns returns progress of runs of this process not finished yet, oldest first
+func RunningCrawlRuns() []*CrawlRun {
+	activeRuns.Lock()
+	defer activeRuns.Unlock()
+
+	runs := make([]*CrawlRun, 0, len(activeRuns.runs))
+	for _, r := range activeRuns.runs {
+		runs = append(runs, &CrawlRun{
+			PagesFetched:       atomic.LoadInt64(&r.PagesFetched),
+			PagesFailed:        atomic.LoadInt64(&r.PagesFailed),
+			ProductsDiscovered: atomic.LoadInt64(&r.ProductsDiscovered),
+			ProductsParsed:     atomic.LoadInt64(&r.ProductsParsed),
+			ProductsUnchanged:  atomic.LoadInt64(&r.ProductsUnchanged),
+			ProductsFailed:     atomic.LoadInt64(&r.ProductsFailed),
+			ID:                 r.ID,
+			Job:                r.Job,
+			RootCategoryID:     r.RootCategoryID,
+			Stage:              r.Stage,
+			SessionID:          r.SessionID,
+			Status:             r.Status,
+			StartedAt:          r.StartedAt,
+		})
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StartedAt.Before(runs[j].StartedAt) })
+
+	return runs
+}
+
+// LastCrawlRuns returns last limit runs of every job, newest first.
+// Runs of the given job only if job is not empty.
+func LastCrawlRuns(ctx context.Context, db *sqlx.DB, job string, limit int) ([]*CrawlRun, error) {
+	query := `SELECT id, job, root_category_id, stage, session_id, status, error,
+	    pages_fetched, pages_failed, products_discovered, products_parsed,
+	    products_unchanged, products_failed, started_at, finished_at
+	  FROM (
+	    SELECT *, ROW_NUMBER() OVER (PARTITION BY job ORDER BY started_at DESC) AS n
+	    FROM crawl_runs WHERE $1 = '' OR job = $1
+	  ) runs
+	  WHERE n <= $2
+	  ORDER BY job, started_at DESC`
+	runs := []*CrawlRun{}
+	if err := db.SelectContext(ctx, &runs, query, job, limit); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,287 @@
+package service_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/isqad/kexpress/db"
+	"github.com/isqad/kexpress/internal/service"
+	"github.com/isqad/kexpress/internal/testserver"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+// testDatabaseEnv names variable with URL of database the crawl tests run against.
+// The database is wiped by every test.
+const testDatabaseEnv = "KEXPRESS_TEST_DATABASE_URL"
+
+// newTestDB connects to test database and recreates its schema
+func newTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	url := os.Getenv(testDatabaseEnv)
+	if url == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+
+	// migrator closes its connection, and Drop removes schema version table,
+	// so the schema is dropped and migrated by separate migrators
+	withMigrate := func(action func(*migrate.Migrate) error) {
+		conn, err := sqlx.Connect("pgx", url)
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		m, err := db.NewMigrate(conn)
+		if err != nil {
+			conn.Close()
+			t.Fatalf("migrator: %v", err)
+		}
+		defer m.Close()
+		if err := action(m); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+	}
+	withMigrate(func(m *migrate.Migrate) error { return m.Drop() })
+	withMigrate(func(m *migrate.Migrate) error { return m.Up() })
+
+	conn, err := sqlx.Connect("pgx", url)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// crawlCounts is rows left by the crawl
+type crawlCounts struct {
+	Categories      int `db:"categories"`
+	Products        int `db:"products"`
+	Observations    int `db:"observations"`
+	Observed        int `db:"observed"`
+	FailedPermanent int `db:"failed_permanent"`
+	Pending         int `db:"pending"`
+	Duplicates      int `db:"duplicates"`
+	Skus            int `db:"skus"`
+	SkuCharValues   int `db:"sku_char_values"`
+	Sellers         int `db:"sellers"`
+}
+
+func countRows(t *testing.T, conn *sqlx.DB) crawlCounts {
+	t.Helper()
+	var c crawlCounts
+	err := conn.Get(&c, `SELECT
+	  (SELECT count(*) FROM categories) AS categories,
+	  (SELECT count(*) FROM products) AS products,
+	  (SELECT count(*) FROM product_observations) AS observations,
+	  (SELECT count(*) FROM product_observations WHERE observed_at IS NOT NULL) AS observed,
+	  (SELECT count(*) FROM product_observations WHERE fetch_error_permanent AND fetch_error IS NOT NULL) AS failed_permanent,
+	  (SELECT count(*) FROM product_observations WHERE observed_at IS NULL AND NOT fetch_error_permanent) AS pending,
+	  (SELECT count(*) FROM (
+	    SELECT product_id FROM product_observations GROUP BY product_id, session_id HAVING count(*) > 1
+	  ) d) AS duplicates,
+	  (SELECT count(*) FROM skus) AS skus,
+	  (SELECT count(*) FROM sku_char_values) AS sku_char_values,
+	  (SELECT count(*) FROM sellers) AS sellers`)
+	if err != nil {
+		t.Fatalf("count rows: %v", err)
+	}
+	return c
+}
+
+// crawl runs categories, listing and products stages of the only root category
+func crawl(t *testing.T, conn *sqlx.DB, client service.MarketplaceClient) {
+	t.Helper()
+	ctx := context.Background()
+	if err := service.CrawlCategories(ctx, conn, client); err != nil {
+		t.Fatalf("CrawlCategories() error = %v", err)
+	}
+
+	var root int64
+	if err := conn.Get(&root, `SELECT id FROM categories WHERE parent_id = 0`); err != nil {
+		t.Fatalf("root category: %v", err)
+	}
+
+	opts := service.CrawlOptions{Job: "e2e", Workers: 2}
+	if err := service.CrawlProductList(ctx, conn, client, root, opts); err != nil {
+		t.Fatalf("CrawlProductList() error = %v", err)
+	}
+	if err := service.CrawlProducts(ctx, conn, client, root, opts); err != nil {
+		t.Fatalf("CrawlProducts() error = %v", err)
+	}
+}
+
+func TestCrawlFakeAPI(t *testing.T) {
+	// listing pages hold 24 items, so every leaf has 3 pages
+	base := testserver.Options{Seed: 1, Roots: 1, Children: 2, Depth: 1, Products: 60, Sellers: 5}
+	const categories = 3
+	const products = 2 * 60
+
+	tests := []struct {
+		name   string
+		faults func(*testserver.Options)
+		check  func(*testing.T, crawlCounts)
+	}{
+		{
+			name:   "clean",
+			faults: func(o *testserver.Options) {},
+			check: func(t *testing.T, c crawlCounts) {
+				if c.Products != products || c.Observations != products || c.Observed != products {
+					t.Errorf("products/observations/observed = %d/%d/%d, want %d each", c.Products, c.Observations, c.Observed, products)
+				}
+			},
+		},
+		{
+			name: "server errors are retried",
+			faults: func(o *testserver.Options) {
+				o.ErrorRate = 0.2
+				o.RateLimitRate = 0.1
+			},
+			check: func(t *testing.T, c crawlCounts) {
+				if c.Products != products || c.Observed != products {
+					t.Errorf("products/observed = %d/%d, want %d each", c.Products, c.Observed, products)
+				}
+			},
+		},
+		{
+			name:   "not found cards fail permanently",
+			faults: func(o *testserver.Options) { o.NotFoundRate = 0.3 },
+			check: func(t *testing.T, c crawlCounts) {
+				if c.Products != products || c.Observations != products {
+					t.Errorf("products/observations = %d/%d, want %d each", c.Products, c.Observations, products)
+				}
+				if c.FailedPermanent == 0 || c.Observed == 0 {
+					t.Errorf("failed permanent/observed = %d/%d, want both present", c.FailedPermanent, c.Observed)
+				}
+				if c.Observed+c.FailedPermanent != products {
+					t.Errorf("observed + failed permanent = %d, want %d", c.Observed+c.FailedPermanent, products)
+				}
+			},
+		},
+		{
+			name:   "duplicated listing items are deduped",
+			faults: func(o *testserver.Options) { o.DuplicateRate = 0.3 },
+			check: func(t *testing.T, c crawlCounts) {
+				// duplicates replace items, so the replaced products are not listed
+				if c.Products == 0 || c.Products >= products {
+					t.Errorf("products = %d, want less than %d", c.Products, products)
+				}
+				if c.Observations != c.Products || c.Observed != c.Products {
+					t.Errorf("observations/observed = %d/%d, want %d each", c.Observations, c.Observed, c.Products)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newTestDB(t)
+			opts := base
+			tt.faults(&opts)
+			srv := httptest.NewServer(testserver.New(opts).Handler())
+			defer srv.Close()
+			// enough attempts for injected server errors to not exhaust them
+			client := service.NewKazanExpressClient(service.ClientOptions{
+				BaseURL: srv.URL,
+				Retry:   service.RetryPolicy{MaxAttempts: 15, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+			})
+			crawl(t, conn, client)
+
+			c := countRows(t, conn)
+			if c.Categories != categories {
+				t.Errorf("categories = %d, want %d", c.Categories, categories)
+			}
+			if c.Pending != 0 {
+				t.Errorf("pending observations = %d, want 0", c.Pending)
+			}
+			if c.Duplicates != 0 {
+				t.Errorf("products observed twice in session = %d, want 0", c.Duplicates)
+			}
+			if c.Observed > 0 && (c.Skus == 0 || c.Sellers == 0 || c.Sellers > base.Sellers) {
+				t.Errorf("skus/sellers = %d/%d, want skus and at most %d sellers", c.Skus, c.Sellers, base.Sellers)
+			}
+			// fake API gives every SKU two characteristic values
+			if c.SkuCharValues != 2*c.Skus {
+				t.Errorf("sku char values = %d, want %d", c.SkuCharValues, 2*c.Skus)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestCrawlResumesTransientFailures(t *testing.T) {
+	conn := newTestDB(t)
+	opts := testserver.Options{Seed: 1, Roots: 1, Children: 2, Depth: 1, Products: 60, Sellers: 5}
+	const products = 2 * 60
+	clean := httptest.NewServer(testserver.New(opts).Handler())
+	defer clean.Close()
+	opts.ErrorRate = 0.3
+	faulty := httptest.NewServer(testserver.New(opts).Handler())
+	defer faulty.Close()
+	// single attempt, so server errors fail listing pages and cards transiently
+	newClient := func(url string) service.MarketplaceClient {
+		return service.NewKazanExpressClient(service.ClientOptions{BaseURL: url, Retry: service.RetryPolicy{MaxAttempts: 1}})
+	}
+
+	ctx := context.Background()
+	if err := service.CrawlCategories(ctx, conn, newClient(clean.URL)); err != nil {
+		t.Fatalf("CrawlCategories() error = %v", err)
+	}
+	var root int64
+	if err := conn.Get(&root, `SELECT id FROM categories WHERE parent_id = 0`); err != nil {
+		t.Fatalf("root category: %v", err)
+	}
+
+	// products stage runs after failed listing as scheduled jobs do
+	crawlStages := func(client service.MarketplaceClient, opts service.CrawlOptions) error {
+		listErr := service.CrawlProductList(ctx, conn, client, root, opts)
+		if err := service.CrawlProducts(ctx, conn, client, root, opts); err != nil {
+			return err
+		}
+		return listErr
+	}
+
+	if err := crawlStages(newClient(faulty.URL), service.CrawlOptions{Job: "e2e", Workers: 2}); err == nil {
+		t.Fatal("crawl of faulty API error = nil, want failed categories")
+	}
+	if c := countRows(t, conn); c.Observed == products {
+		t.Fatalf("all %d products observed, want transient failures", products)
+	}
+	var failedRuns int
+	if err := conn.Get(&failedRuns, `SELECT count(*) FROM crawl_runs WHERE status = $1`, service.RunFailed); err != nil {
+		t.Fatalf("failed runs: %v", err)
+	}
+	if failedRuns == 0 {
+		t.Error("no crawl runs recorded as failed")
+	}
+
+	// failed runs are resumed, so healthy API retries failed pages and cards
+	if err := crawlStages(newClient(clean.URL), service.CrawlOptions{Job: "e2e", Workers: 2, Resume: true}); err != nil {
+		t.Fatalf("resumed crawl error = %v", err)
+	}
+
+	c := countRows(t, conn)
+	if c.Products != products || c.Observations != products || c.Observed != products {
+		t.Errorf("products/observations/observed = %d/%d/%d, want %d each", c.Products, c.Observations, c.Observed, products)
+	}
+	if c.Pending != 0 {
+		t.Errorf("pending observations = %d, want 0", c.Pending)
+	}
+}
+
+func TestCrawlProductsWithoutListing(t *testing.T) {
+	conn := newTestDB(t)
+	client := newFakeClient(t, testserver.Options{Seed: 1, Roots: 1, Children: 1, Depth: 1, Products: 3, Sellers: 2})
+	crawl(t, conn, client)
+
+	var root int64
+	if err := conn.Get(&root, `SELECT id FROM categories WHERE parent_id = 0`); err != nil {
+		t.Fatalf("root category: %v", err)
+	}
+	// listing of the crawl is recorded under job e2e
+	if err := service.CrawlProducts(context.Background(), conn, client, root, service.CrawlOptions{Job: "manual"}); err == nil {
+		t.Error("CrawlProducts() of job without listing error = nil")
+	}
+}
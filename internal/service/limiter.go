@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket shared by all outbound requests.
+// It limits both requests per second and requests in flight.
+type Limiter struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	inFlight chan struct{}
+}
+
+// NewLimiter creates limiter allowing rps requests per second and at most
+// maxInFlight concurrent requests. Zero or negative values disable the limit.
+func NewLimiter(rps float64, maxInFlight int) *Limiter {
+	l := &Limiter{
+		rate:  rps,
+		burst: math.Max(1, math.Ceil(rps)),
+		last:  time.Now(),
+	}
+	l.tokens = l.burst
+	if maxInFlight > 0 {
+		l.inFlight = make(chan struct{}, maxInFlight)
+	}
+
+	return l
+}
+
+// Acquire blocks until request is allowed or ctx is done. Returned func must be
+// called when the request is finished.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release := func() {
+		if l.inFlight != nil {
+			<-l.inFlight
+		}
+	}
+	if err := l.wait(ctx); err != nil {
+		release()
+		return nil, err
+	}
+
+	return release, nil
+}
+
+func (l *Limiter) wait(ctx context.Context) error {
+	if l.rate <= 0 {
+		return nil
+	}
+
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep pauses for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiterRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		rps      float64
+		requests int
+		min      time.Duration
+	}{
+		// burst of ceil(rps) is allowed at once, the rest is spread by rate
+		{name: "burst", rps: 10, requests: 10, min: 0},
+		{name: "over burst", rps: 20, requests: 25, min: 200 * time.Millisecond},
+		{name: "fractional rate", rps: 0.5, requests: 1, min: 0},
+		{name: "unlimited", rps: 0, requests: 1000, min: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.rps, 0)
+			start := time.Now()
+			for i := 0; i < tt.requests; i++ {
+				release, err := l.Acquire(context.Background())
+				if err != nil {
+					t.Fatalf("Acquire() error = %v", err)
+				}
+				release()
+			}
+			if elapsed := time.Since(start); elapsed < tt.min {
+				t.Errorf("%d requests took %s, want at least %s", tt.requests, elapsed, tt.min)
+			}
+		})
+	}
+}
+
+func TestLimiterInFlight(t *testing.T) {
+	const maxInFlight = 3
+	l := NewLimiter(0, maxInFlight)
+
+	var inFlight, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := l.Acquire(context.Background())
+			if err != nil {
+				t.Errorf("Acquire() error = %v", err)
+				return
+			}
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+			release()
+		}()
+	}
+	wg.Wait()
+
+	if peak > maxInFlight {
+		t.Errorf("peak requests in flight = %d, want at most %d", peak, maxInFlight)
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	t.Run("waiting for slot", func(t *testing.T) {
+		l := NewLimiter(0, 1)
+		release, err := l.Acquire(context.Background())
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		defer release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := l.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Acquire() error = %v, want deadline exceeded", err)
+		}
+	})
+
+	t.Run("waiting for token", func(t *testing.T) {
+		l := NewLimiter(0.1, 1)
+		release, err := l.Acquire(context.Background())
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := l.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Acquire() error = %v, want deadline exceeded", err)
+		}
+		// slot of canceled request is released
+		if len(l.inFlight) != 0 {
+			t.Errorf("%d slots are held after cancel, want 0", len(l.inFlight))
+		}
+	})
+
+	t.Run("nil limiter", func(t *testing.T) {
+		var l *Limiter
+		release, err := l.Acquire(context.Background())
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		release()
+	})
+}
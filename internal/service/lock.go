@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// instanceID identifies this process among crawler replicas
+var instanceID = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}()
+
+// JobLock is Postgres advisory lock held by this process.
+// Lock is released on Unlock or when the process dies and its connection is closed.
+type JobLock struct {
+	name string
+	conn *sqlx.Conn
+}
+
+// TryLockJob acquires lock of the job without waiting for other processes.
+// Returns nil lock if the job is locked by another process.
+func TryLockJob(ctx context.Context, db *sqlx.DB, job string) (*JobLock, error) {
+	// advisory locks belong to the session, so the lock keeps its own connection
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.GetContext(ctx, &locked, `SELECT pg_try_advisory_lock(hashtextextended($1, 0))`, "job:"+job); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !locked {
+		conn.Close()
+		return nil, nil
+	}
+
+	return &JobLock{name: job, conn: conn}, nil
+}
+
+// Unlock releases the lock. It runs even if ctx is done, e.g. on shutdown,
+// bounded by writeTimeout.
+func (l *JobLock) Unlock(ctx context.Context) error {
+	defer l.conn.Close()
+	ctx, cancel := detachedContext(ctx)
+	defer cancel()
+
+	_, err := l.conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtextextended($1, 0))`, "job:"+l.name)
+	return err
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Endpoints of the marketplace API as they are labeled in metrics
+const (
+	endpointCategories  = "categories"
+	endpointProductList = "product_list"
+	endpointProduct     = "product"
+)
+
+// Crawler metrics are registered in the default registry
+var (
+	upstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kexpress",
+		Name:      "upstream_requests_total",
+		Help:      "Requests to the marketplace API by endpoint and HTTP status, error if no response.",
+	}, []string{"endpoint", "status"})
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kexpress",
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Latency of requests to the marketplace API excluding rate limiter wait.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	crawlPages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kexpress",
+		Name:      "crawl_pages_total",
+		Help:      "Listing pages by result: fetched or failed.",
+	}, []string{"crawl_job", "root", "result"})
+
+	crawlProducts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kexpress",
+		Name:      "crawl_products_total",
+		Help:      "Products by result: discovered, parsed, unchanged (deduped by fingerprint) or failed.",
+	}, []string{"crawl_job", "root", "result"})
+
+	crawlLastRunProducts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kexpress",
+		Name:      "crawl_last_run_products",
+		Help:      "Products of the last finished run of the job stage by result.",
+	}, []string{"crawl_job", "root", "stage", "result"})
+
+	crawlLastRunFinished = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kexpress",
+		Name:      "crawl_last_run_finished_timestamp_seconds",
+		Help:      "Time the last run of the job stage finished.",
+	}, []string{"crawl_job", "root", "stage"})
+
+	crawlLastRunFailed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kexpress",
+		Name:      "crawl_last_run_failed",
+		Help:      "Whether the last finished run of the job stage failed, e.g. some of its categories.",
+	}, []string{"crawl_job", "root", "stage"})
+
+	crawlWorkers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kexpress",
+		Name:      "crawl_workers",
+		Help:      "Started workers of the crawl stage.",
+	}, []string{"stage"})
+
+	crawlWorkersBusy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kexpress",
+		Name:      "crawl_workers_busy",
+		Help:      "Workers of the crawl stage processing a category or product.",
+	}, []string{"stage"})
+)
+
+// runLabels returns crawl job and root labels of the run
+func (r *CrawlRun) runLabels(result string) prometheus.Labels {
+	return prometheus.Labels{"crawl_job": r.Job, "root": strconv.FormatInt(r.RootCategoryID, 10), "result": result}
+}
+
+// observeLastRun exports counters of the finished run
+func (r *CrawlRun) observeLastRun() {
+	root := strconv.FormatInt(r.RootCategoryID, 10)
+	results := map[string]int64{
+		"discovered": r.ProductsDiscovered,
+		"parsed":     r.ProductsParsed,
+		"unchanged":  r.ProductsUnchanged,
+		"failed":     r.ProductsFailed,
+	}
+	for result, n := range results {
+		crawlLastRunProducts.WithLabelValues(r.Job, root, r.Stage, result).Set(float64(n))
+	}
+	crawlLastRunFinished.WithLabelValues(r.Job, root, r.Stage).SetToCurrentTime()
+	failed := 0.0
+	if r.Status == RunFailed {
+		failed = 1
+	}
+	crawlLastRunFailed.WithLabelValues(r.Job, root, r.Stage).Set(failed)
+}
+
+// workerPool tracks occupancy of stage workers
+type workerPool struct {
+	size int
+	busy prometheus.Gauge
+	all  prometheus.Gauge
+}
+
+func newWorkerPool(stage string, size int) *workerPool {
+	p := &workerPool{
+		size: size,
+		busy: crawlWorkersBusy.WithLabelValues(stage),
+		all:  crawlWorkers.WithLabelValues(stage),
+	}
+	p.all.Add(float64(size))
+	return p
+}
+
+// acquire marks worker busy until the returned func is called
+func (p *workerPool) acquire() func() {
+	p.busy.Inc()
+	return p.busy.Dec
+}
+
+// close marks workers of the pool finished
+func (p *workerPool) close() {
+	p.all.Sub(float64(p.size))
+}
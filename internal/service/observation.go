@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// observation is product seen in crawl session.
+// It is pending until the product card is fetched.
+type observation struct {
+	ID        int64 `db:"id"`
+	ProductID int64 `db:"product_id"`
+	PortalID  int64 `db:"portal_id"`
+	SessionID int64 `db:"session_id"`
+}
+
+// upsertProduct creates product of the listed item or updates its listing fields
+// and records pending observation of it in the item session.
+// Reports whether the product had not been observed in the session yet.
+func upsertProduct(ctx context.Context, q sqlx.ExtContext, item *ProductOfList) (int64, bool, error) {
+	var productID int64
+	if err := sqlx.GetContext(ctx, q, &productID, `INSERT INTO products
+	  (portal_id, title, portal_category_id, category_id, rating, session_id, search_vector, created_at)
+	  VALUES ($1, $2, $3, $4, $5, $6, setweight(to_tsvector('russian', $7), 'A'), NOW())
+	  ON CONFLICT ON CONSTRAINT uniq_portal_id_products DO UPDATE SET
+	    title = EXCLUDED.title,
+	    portal_category_id = EXCLUDED.portal_category_id,
+	    category_id = EXCLUDED.category_id,
+	    rating = EXCLUDED.rating,
+	    session_id = EXCLUDED.session_id,
+	    search_vector = EXCLUDED.search_vector ||
+	      setweight(to_tsvector('russian', COALESCE(products.description, '')), 'B')
+	  RETURNING id`,
+		item.PortalID, item.Title, item.PortalCategoryID, item.CategoryID, item.Rating, item.SessionID, item.Title); err != nil {
+		return 0, false, err
+	}
+
+	res, err := q.ExecContext(ctx, `INSERT INTO product_observations (product_id, session_id, category_id, rating, created_at)
+	  VALUES ($1, $2, $3, $4, NOW())
+	  ON CONFLICT ON CONSTRAINT uniq_product_id_session_id_product_observations DO NOTHING`,
+		productID, item.SessionID, item.CategoryID, item.Rating)
+	if err != nil {
+		return 0, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, err
+	}
+
+	return productID, n > 0, nil
+}
+
+// claimTimeout is time after which observations claimed by crashed replica are claimed again
+const claimTimeout = "30 minutes"
+
+// claimObservations claims up to limit pending observations of category in the session
+// with ID greater than afterID. Observations claimed or locked by other replicas are skipped.
+func claimObservations(ctx context.Context, db *sqlx.DB, sessionID int64, categoryID int64, afterID int64, limit int64) ([]*observation, error) {
+	query := `UPDATE product_observations o SET claimed_by = $1, claimed_at = NOW()
+	  FROM products p
+	  WHERE p.id = o.product_id AND o.id IN (
+	    SELECT id FROM product_observations
+	    WHERE session_id = $2
+	    AND category_id = $3
+	    AND id > $4
+	    AND observed_at IS NULL
+	    AND NOT fetch_error_permanent
+	    AND (claimed_at IS NULL OR claimed_at < NOW() - $6::interval)
+	    ORDER BY id ASC
+	    LIMIT $5
+	    FOR UPDATE SKIP LOCKED
+	  )
+	  RETURNING o.id, o.product_id, p.portal_id, o.session_id`
+	observations := []*observation{}
+	if err := db.SelectContext(ctx, &observations, query, instanceID, sessionID, categoryID, afterID, limit, claimTimeout); err != nil {
+		return nil, err
+	}
+	sort.Slice(observations, func(i, j int) bool { return observations[i].ID < observations[j].ID })
+
+	return observations, nil
+}
+
+// skippedObservations returns IDs of pending observations of category in the session
+// with ID in (afterID, toID] claimed by other instances, zero toID means no upper bound.
+// Claims time out as in claimObservations.
+func skippedObservations(ctx context.Context, db *sqlx.DB, sessionID int64, categoryID int64, afterID int64, toID int64) ([]int64, error) {
+	ids := []int64{}
+	err := db.SelectContext(ctx, &ids, `SELECT id FROM product_observations
+	  WHERE session_id = $1
+	  AND category_id = $2
+	  AND id > $3
+	  AND ($4::bigint = 0 OR id <= $4::bigint)
+	  AND observed_at IS NULL
+	  AND NOT fetch_error_permanent
+	  AND claimed_by != $5
+	  AND claimed_at >= NOW() - $6::interval
+	  ORDER BY id ASC`, sessionID, categoryID, afterID, toID, instanceID, claimTimeout)
+	return ids, err
+}
+
+// releaseForeignClaims returns pending observations of the session claimed by
+// other instances. Resumed stage runs under the job lock, so the claimants are
+// replicas crashed or stopped before they released their claims.
+func releaseForeignClaims(ctx context.Context, db *sqlx.DB, sessionID int64) (int64, error) {
+	res, err := db.ExecContext(ctx, `UPDATE product_observations SET claimed_by = NULL, claimed_at = NULL
+	  WHERE session_id = $1 AND observed_at IS NULL AND claimed_by != $2`, sessionID, instanceID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// pending reports whether the observation still waits for product card
+func (o *observation) pending(ctx context.Context, db *sqlx.DB) (bool, error) {
+	var pending bool
+	err := db.GetContext(ctx, &pending, `SELECT observed_at IS NULL FROM product_observations WHERE id = $1`, o.ID)
+	return pending, err
+}
+
+// release returns pending observation to other replicas and resumed runs
+func (o *observation) release(ctx context.Context, db *sqlx.DB) error {
+	_, err := db.ExecContext(ctx, `UPDATE product_observations SET claimed_by = NULL, claimed_at = NULL
+	  WHERE id = $1 AND observed_at IS NULL`, o.ID)
+	return err
+}
+
+// saveFetchError records the final outcome of failed product card fetch.
+// Observations failed permanently are not fetched again, failed transiently
+// stay pending for resumed run.
+func (o *observation) saveFetchError(ctx context.Context, db *sqlx.DB, fetchErr error) error {
+	_, err := db.ExecContext(ctx, `UPDATE product_observations SET
+	  fetch_attempts = fetch_attempts + $2,
+	  fetch_error = $3,
+	  fetch_error_permanent = $4,
+	  fetch_failed_at = NOW() WHERE id = $1`,
+		o.ID, fetchAttempts(fetchErr), fetchErr.Error(), IsPermanent(fetchErr))
+	return err
+}
+
+// observe records fetched card of the product as the observation in one transaction.
+// Product is saved only if the card changed since it was saved last time,
+// otherwise the observation is marked unchanged.
+// Crawl workers pass detached context, so fetched card is not left half recorded on shutdown.
+func (p *Product) observe(ctx context.Context, db *sqlx.DB, o *observation) (unchanged bool, err error) {
+	p.ID = o.ProductID
+	p.SessionID = o.SessionID
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := p.saveSkuSnapshots(ctx, tx); err != nil {
+		return false, err
+	}
+	if err := p.saveSeller(ctx, tx); err != nil {
+		return false, err
+	}
+
+	p.calcFingerprint()
+	if err := tx.GetContext(ctx, &unchanged, `SELECT fingerprint = $2 FROM products WHERE id = $1`, p.ID, p.Fingerprint); err != nil {
+		return false, err
+	}
+	if !unchanged {
+		if err := p.save(ctx, tx); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE product_observations SET
+	  orders_amount = $2,
+	  reviews_amount = $3,
+	  total_available_amount = $4,
+	  rating = $5,
+	  price = $6,
+	  fingerprint = $7,
+	  unchanged = $8,
+	  observed_at = NOW() WHERE id = $1`,
+		o.ID, p.OrdersAmount, p.ReviewsAmount, p.TotalAvailableAmount, p.Rating, p.price(), p.Fingerprint, unchanged); err != nil {
+		return false, err
+	}
+
+	return unchanged, tx.Commit()
+}
+
+// ProductHistory returns observations of product between from and to ordered by time
+func ProductHistory(ctx context.Context, db *sqlx.DB, portalID int64, from time.Time, to time.Time) ([]*ProductObservation, error) {
+	history := []*ProductObservation{}
+	err := db.SelectContext(ctx, &history, `SELECT p.portal_id,
+	    COALESCE(o.category_id, 0) AS category_id,
+	    COALESCE(p.seller_id, 0) AS seller_id,
+	    o.orders_amount,
+	    o.total_available_amount,
+	    o.price::float8 AS price,
+	    o.unchanged,
+	    o.observed_at
+	  FROM product_observations o
+	  JOIN products p ON p.id = o.product_id
+	  WHERE p.portal_id = $1 AND o.observed_at BETWEEN $2 AND $3
+	  ORDER BY o.observed_at`, portalID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
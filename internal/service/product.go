@@ -1,18 +1,15 @@
 package service
 
 import (
+	"context"
 	"crypto/md5"
-	"database/sql"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
-	"math/rand"
-	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/isqad/kexpress/internal/logger"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -49,6 +46,27 @@ type Product struct {
 	Characteristics      []*Characteristic `json:"characteristics" db:"-"`
 	SkuList              []*Sku            `json:"skuList" db:"-"`
 	Fingerprint          string            `json:"-" db:"fingerprint"`
+	SessionID            int64             `json:"-" db:"session_id"`
+}
+
+// description returns description of the card, empty if the card has none
+func (p *Product) description() string {
+	if p.Description == nil {
+		return ""
+	}
+	return *p.Description
+}
+
+// price returns the lowest purchase price of the card SKUs, zero if it has none.
+// Products shows and filters products by the same price.
+func (p *Product) price() float32 {
+	var price float32
+	for i, s := range p.SkuList {
+		if i == 0 || s.PurchasePrice < price {
+			price = s.PurchasePrice
+		}
+	}
+	return price
 }
 
 func (p *Product) calcFingerprint() {
@@ -82,87 +100,62 @@ func (p *Product) calcFingerprint() {
 
 	productStr := fmt.Sprintf(
 		"id:%d|descr:%s|rating:%.2f|orders:%d|avail:%d|sku:%s",
-		p.PortalID, *p.Description, p.Rating, p.OrdersAmount, p.TotalAvailableAmount, sb.String(),
+		p.PortalID, p.description(), p.Rating, p.OrdersAmount, p.TotalAvailableAmount, sb.String(),
 	)
 
 	p.Fingerprint = fmt.Sprintf("%x", md5.Sum([]byte(productStr)))
 }
 
-func (p *Product) fingerprintExists(db *sqlx.DB) (bool, error) {
-	var e int
-	err := db.Get(&e, `SELECT 1 FROM products WHERE fingerprint = $1 AND id != $2 LIMIT 1`, p.Fingerprint, p.ID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, nil
-		}
-		return false, err
-	}
-
-	return true, nil
-}
-
-func (p *Product) remove(db *sqlx.DB) error {
-	_, err := db.Exec(`DELETE FROM products WHERE id = $1`, p.ID)
-	return err
-}
-
-func (p *Product) save(db *sqlx.DB) error {
-	// check before
-	var exist int
-	// p.CategoryTitle = &p.Category.Title
-	p.SellerID = &p.Seller.PortalID
-	p.SellerTitle = &p.Seller.Title
-	// Start a new transaction
-	tx := db.MustBegin()
-	if err := tx.Get(&exist, `SELECT 1 FROM products WHERE id = $1 LIMIT 1 FOR UPDATE NOWAIT`, p.ID); err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("ERROR: NOWAIT: Product %d already parsed\n", p.ID)
-			return nil
-		}
-		tx.Rollback()
-		return err
+// save replaces stored product card with the fetched one in tx of the observation.
+// Seller of product without seller is stored as NULL, missing description as empty one.
+func (p *Product) save(ctx context.Context, tx *sqlx.Tx) error {
+	l := logger.FromContext(ctx)
+	description := p.description()
+	p.Description = &description
+	p.SellerID, p.SellerTitle = nil, nil
+	if p.Seller != nil {
+		p.SellerID = &p.Seller.PortalID
+		p.SellerTitle = &p.Seller.Title
 	}
 
 	charValues := make(map[int]map[int]int64)
 
 	if len(p.Characteristics) > 0 {
 		// save chars
-		log.Printf("Saving characteristics for product: #%d %s\n", p.PortalID, p.Title)
 		for ic, c := range p.Characteristics {
 
 			charValues[ic] = make(map[int]int64)
 
 			if err := c.save(tx); err != nil {
-				tx.Rollback()
 				return err
 			}
 			if len(c.Values) == 0 {
-				log.Printf("No Values given for characteristic: %s\n", c.Title)
+				l.Debugf("no values of characteristic %s", c.Title)
 				continue
 			}
-			log.Printf("Saving characteristic values for product: #%d %s\n", p.PortalID, p.Title)
 			for icv, cv := range c.Values {
 				cv.CharID = c.ID
 				if err := cv.save(tx); err != nil {
-					tx.Rollback()
 					return err
 				}
 				charValues[ic][icv] = cv.ID
 			}
 		}
-		log.Printf("Gathered charValues: %+v\n", charValues)
 	} else {
-		log.Printf("No Characteristics given for product: #%d %s\n", p.PortalID, p.Title)
+		l.Debugf("no characteristics of product")
+	}
+
+	// SKUs of the previous card are replaced, their history is kept in snapshots
+	if _, err := tx.ExecContext(ctx, `DELETE FROM skus WHERE product_id = $1`, p.ID); err != nil {
+		return err
 	}
 
 	// save sku list
 	if len(p.SkuList) > 0 {
-		log.Printf("Saving skuList for product: #%d %s\n", p.PortalID, p.Title)
 		for _, s := range p.SkuList {
 			s.ProductID = p.ID
 
 			if err := s.save(tx); err != nil {
-				tx.Rollback()
 				return err
 			}
 
@@ -174,7 +167,6 @@ func (p *Product) save(db *sqlx.DB) error {
 							CharValueID: v,
 						}
 						if err := skuCharValue.save(tx); err != nil {
-							tx.Rollback()
 							return err
 						}
 					}
@@ -182,7 +174,7 @@ func (p *Product) save(db *sqlx.DB) error {
 			}
 		}
 	} else {
-		log.Printf("No SkuList given for product: #%d %s\n", p.PortalID, p.Title)
+		l.Debugf("no SKUs of product")
 	}
 
 	query := `UPDATE products SET
@@ -194,35 +186,101 @@ func (p *Product) save(db *sqlx.DB) error {
 	  seller_title = :seller_title,
 	  description = :description,
 	  fingerprint = :fingerprint,
+	  search_vector = setweight(to_tsvector('russian', COALESCE(title, '')), 'A') ||
+	    setweight(to_tsvector('russian', COALESCE(:description, '')), 'B'),
 	  parsed_at = NOW() WHERE id = :id`
-	if _, err := tx.NamedExec(query, p); err != nil {
-		tx.Rollback()
-		return err
+	_, err := tx.NamedExecContext(ctx, query, p)
+	return err
+}
+
+// CrawlProduct fetches product card and records it as observation of a new session.
+// Product is saved only if it changed since the last crawl.
+func CrawlProduct(ctx context.Context, db *sqlx.DB, client MarketplaceClient, portalID int64) (*Product, error) {
+	p, err := client.FetchProduct(ctx, portalID)
+	if err != nil {
+		return nil, err
+	}
+	if p.Category == nil {
+		return nil, fmt.Errorf("product %d has no category", portalID)
 	}
 
-	tx.Commit()
-	return nil
-}
+	c, err := findCategoryByPortalID(ctx, db, p.Category.PortalID)
+	if err != nil {
+		return nil, fmt.Errorf("category %d of product %d: %w", p.Category.PortalID, portalID, err)
+	}
 
-const batchSize = 100
+	item := &ProductOfList{
+		PortalID:         p.PortalID,
+		Title:            p.Title,
+		CategoryID:       c.ID,
+		PortalCategoryID: c.PortalID,
+		Rating:           p.Rating,
+		SessionID:        time.Now().UnixNano(),
+	}
+	o := &observation{PortalID: p.PortalID, SessionID: item.SessionID}
+	if o.ProductID, _, err = upsertProduct(ctx, db, item); err != nil {
+		return nil, err
+	}
+	if err := db.GetContext(ctx, &o.ID, `SELECT id FROM product_observations WHERE product_id = $1 AND session_id = $2`,
+		o.ProductID, o.SessionID); err != nil {
+		return nil, err
+	}
+
+	l := logger.FromContext(ctx).With("portal_id", portalID, "session_id", o.SessionID)
+	unchanged, err := p.observe(logger.NewContext(ctx, l), db, o)
+	if err != nil {
+		return nil, err
+	}
+	if unchanged {
+		l.Infof("product is unchanged, fingerprint %s", p.Fingerprint)
+	}
 
-type parseError struct {
-	Error      error
-	CategoryID int64
+	return p, nil
 }
 
-// CrawlProducts crawl all not parsed products
-func CrawlProducts(db *sqlx.DB, rootCategoryID int64) error {
+const batchSize = 100
+
+// CrawlProducts crawl all not parsed products.
+// Failed categories don't stop the rest, the run fails with their error after all are parsed.
+func CrawlProducts(ctx context.Context, db *sqlx.DB, client MarketplaceClient, rootCategoryID int64, opts CrawlOptions) (err error) {
 	var wg sync.WaitGroup
 
-	leaves, err := CategoryLeaves(db, rootCategoryID)
+	sessionID, resumed, err := opts.session(ctx, db, rootCategoryID, StageProducts)
 	if err != nil {
 		return err
 	}
 
-	workerPoolSize := 100
+	run, err := startCrawlRun(ctx, db, opts.job(), StageProducts, rootCategoryID, sessionID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if ferr := run.finish(err); ferr != nil {
+			run.log.With("error", ferr).Errorf("finish crawl run")
+		}
+	}()
+	ctx = run.context(ctx)
+
+	leaves, err := opts.leaves(ctx, db, rootCategoryID)
+	if err != nil {
+		return err
+	}
+	checkpoints, err := stageCheckpoints(ctx, db, sessionID, StageProducts, resumed)
+	if err != nil {
+		return err
+	}
+	if resumed {
+		n, err := releaseForeignClaims(ctx, db, sessionID)
+		if err != nil {
+			return err
+		}
+		run.log.Infof("released %d observations claimed by stopped instances", n)
+	}
+
+	workerPoolSize := opts.workers()
 
 	dataCh := make(chan int64, workerPoolSize)
+	var failures categoryFailures
 
 	for i := 0; i < workerPoolSize; i++ {
 		wg.Add(1)
@@ -230,169 +288,245 @@ func CrawlProducts(db *sqlx.DB, rootCategoryID int64) error {
 			defer wg.Done()
 
 			for categoryID := range dataCh {
-				log.Printf("INFO: got category %d to parse\n", categoryID)
-				if err := parseProducts(db, categoryID, batchSize); err != nil {
-					log.Printf("ERROR: category %d, err: %v\n", categoryID, err)
+				l := run.log.With("category_id", categoryID)
+				cp, ok := checkpoints[categoryID]
+				if !ok {
+					cp = newCheckpoint(sessionID, StageProducts, categoryID)
+				}
+				if cp.Done {
+					l.Infof("category already parsed in session")
+					continue
+				}
+
+				if err := parseProducts(logger.NewContext(ctx, l), db, client, run, cp, batchSize); err != nil {
+					l.With("error", err).Errorf("parse category failed")
+					if ctx.Err() == nil {
+						failures.add(categoryID, err)
+					}
 					continue
 				}
-				log.Printf("INFO: category %d parsed successfully!\n", categoryID)
+				l.Infof("category parsed")
 			}
 
 		}()
 	}
 
+dispatch:
 	for _, category := range leaves {
-		categoryID := category.ID
-		dataCh <- categoryID
+		select {
+		case dataCh <- category.ID:
+		case <-ctx.Done():
+			run.log.Infof("parsing interrupted")
+			break dispatch
+		}
 	}
 	close(dataCh)
 
 	wg.Wait()
 
-	return nil
-}
-
-// ParseProducts parses products from category
-func parseProducts(db *sqlx.DB, categoryID int64, batchSize int64) error {
-	var wg sync.WaitGroup
-
-	minMaxIDQuery := `SELECT MIN(id) AS min_id, MAX(id) AS max_id FROM products
-	  WHERE parsed_at IS NULL AND category_id = $1 LIMIT 1`
-
-	minMaxID := &struct {
-		MinID *int64 `db:"min_id"`
-		MaxID *int64 `db:"max_id"`
-	}{}
-
-	if err := db.Get(minMaxID, minMaxIDQuery, categoryID); err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("INFO: No unparsed products for category #%d\n", categoryID)
-			return nil
-
-		}
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	return failures.err()
+}
 
-	if minMaxID.MinID == nil {
-		return nil
-	}
+// ParseProducts parses products from category following the checkpoint.
+// When ctx is done claimed observations are released and the category is left
+// unfinished, so the checkpoint is resumed from the last processed observation.
+// Observations claimed by other instances hold the checkpoint and leave the
+// category unfinished as well. Observations failed transiently or not recorded
+// because of failed save do it too, and the category fails then.
+func parseProducts(ctx context.Context, db *sqlx.DB, client MarketplaceClient, run *CrawlRun, cp *checkpoint, batchSize int64) error {
+	var wg sync.WaitGroup
+	categoryID := cp.CategoryID
+	l := logger.FromContext(ctx)
 
 	workerPoolSize := 2
-	dataCh := make(chan *Product, batchSize)
+	dataCh := make(chan *observation, batchSize)
+	progress := newWatermark(cp.LastProductID)
+	var cpMu sync.Mutex
+	var failed int64
+
+	pool := newWorkerPool(StageProducts, workerPoolSize)
+	defer pool.close()
 
 	for i := 0; i < workerPoolSize; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 
-			for product := range dataCh {
-				var exist int
-				if err := db.Get(&exist, `SELECT 1 FROM products WHERE id = $1 AND parsed_at IS NULL LIMIT 1`, product.ID); err != nil {
-					if err == sql.ErrNoRows {
-						log.Printf("ERROR: CHECKED: Product %d already parsed\n", product.ID)
-						continue
-					}
-					log.Printf("ERROR: Load product failed: %v\n", err)
-					continue
-				}
-
-				p, err := loadProduct(product.PortalID)
+			for o := range dataCh {
+				done := pool.acquire()
+				err := parseProduct(ctx, db, client, run, o)
+				done()
 				if err != nil {
-					log.Printf("ERROR: Load product failed: %v\n", err)
-					continue
-				}
-				p.ID = product.ID
-				log.Printf("INFO: Product loaded: %+v\n", p)
-
-				p.calcFingerprint()
-				log.Printf("INFO: Check fingerprint: %s\n", p.Fingerprint)
-				exists, err := p.fingerprintExists(db)
-				if err != nil {
-					log.Printf("ERROR: Saving product failed: %v\n", err)
-					continue
-				}
-
-				if exists {
-					log.Printf("INFO: fingerprint exists: %s, remove duplicate\n", p.Fingerprint)
-					if err := p.remove(db); err != nil {
-						log.Printf("ERROR: Deleting product failed: %v\n", err)
+					releaseObservations(ctx, db, []*observation{o})
+					if ctx.Err() == nil {
+						atomic.AddInt64(&failed, 1)
 					}
 					continue
 				}
 
-				if err = p.save(db); err != nil {
-					log.Printf("ERROR: Saving product failed: %v\n", err)
-					continue
+				if mark, moved := progress.processed(o.ID); moved {
+					cpMu.Lock()
+					if mark > cp.LastProductID {
+						cp.LastProductID = mark
+						wctx, cancel := detachedContext(ctx)
+						if err := cp.save(wctx, db); err != nil {
+							l.With("error", err).Errorf("save checkpoint failed")
+						}
+						cancel()
+					}
+					cpMu.Unlock()
 				}
-				timeout := rand.Intn(4)
-				log.Printf("Product %d has been parsed. Sleep %ds\n", product.ID, timeout)
-				time.Sleep(time.Duration(timeout) * time.Second)
 			}
 
 		}()
 	}
 
-	query := `SELECT portal_id, id
-	  FROM products
-	  WHERE id BETWEEN $1 AND $2
-	  AND session_id > 0
-	  AND NOW()::date - to_timestamp(session_id / 1000000000)::date <= 1
-	  AND parsed_at IS NULL
-	  AND category_id = $3 ORDER BY id ASC`
-	startBatch := *minMaxID.MinID
-
-	log.Printf("Start parse products for category_id: %d, min_id: %d, max_id: %d\n",
-		categoryID, startBatch, *minMaxID.MaxID)
+	l.Infof("parse products after observation %d", cp.LastProductID)
 
-	for startBatch <= *minMaxID.MaxID {
-		nextID := startBatch + batchSize
-		products := []*Product{}
-		if err := db.Select(&products, query, startBatch, nextID, categoryID); err != nil {
+	lastID := cp.LastProductID
+	for {
+		observations, err := claimObservations(ctx, db, cp.SessionID, categoryID, lastID, batchSize)
+		if err != nil {
+			close(dataCh)
+			wg.Wait()
+			return err
+		}
+		var toID int64
+		if len(observations) > 0 {
+			toID = observations[len(observations)-1].ID
+		}
+		// observations claimed by other instances are dispatched but never
+		// processed, so the checkpoint doesn't move past them
+		skipped, err := skippedObservations(ctx, db, cp.SessionID, categoryID, lastID, toID)
+		if err != nil {
+			releaseObservations(ctx, db, observations)
+			close(dataCh)
+			wg.Wait()
 			return err
 		}
 
-		for _, product := range products {
-			dataCh <- product
+		j := 0
+		for i, o := range observations {
+			if ctx.Err() != nil {
+				releaseObservations(ctx, db, observations[i:])
+				break
+			}
+			for ; j < len(skipped) && skipped[j] < o.ID; j++ {
+				progress.dispatched(skipped[j])
+			}
+			progress.dispatched(o.ID)
+			dataCh <- o
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		for ; j < len(skipped); j++ {
+			progress.dispatched(skipped[j])
+		}
+		if len(observations) == 0 {
+			break
 		}
 
-		startBatch = nextID
+		lastID = toID
 	}
 	close(dataCh)
 
 	wg.Wait()
 
-	log.Printf("INFO: all products from category %d has been loaded\n", categoryID)
+	if err := ctx.Err(); err != nil {
+		l.Infof("parsing interrupted after observation %d", cp.LastProductID)
+		return err
+	}
 
-	return nil
+	if n := atomic.LoadInt64(&failed); n > 0 {
+		return fmt.Errorf("%d products failed after observation %d, category is left unfinished", n, cp.LastProductID)
+	}
+	if !progress.complete() {
+		l.Warnf("observations after %d are claimed by other instances, category is left unfinished", cp.LastProductID)
+		return nil
+	}
+
+	l.Infof("all products parsed")
+
+	cp.Done = true
+	return cp.save(ctx, db)
 }
 
-func loadProduct(portalID int64) (*Product, error) {
-	url := fmt.Sprintf("https://api.kazanexpress.ru/api/v2/product/%d", portalID)
-	client := &http.Client{
-		Timeout: 120 * time.Second,
+// releaseObservations releases claimed observations that are not processed.
+// Claims are released even if ctx is done, so resumed runs and other replicas
+// don't wait for claim timeout.
+func releaseObservations(ctx context.Context, db *sqlx.DB, observations []*observation) {
+	l := logger.FromContext(ctx)
+	ctx, cancel := detachedContext(ctx)
+	defer cancel()
+	for _, o := range observations {
+		if err := o.release(ctx, db); err != nil {
+			l.With("portal_id", o.PortalID, "error", err).Errorf("release observation failed")
+		}
 	}
-	req, err := newRequest(url)
-	if err != nil {
-		return nil, err
+}
+
+// parseProduct loads product card of the pending observation and records it.
+// Returns error if ctx is done before the card is fetched, the card fetch failed
+// transiently or the card is not recorded, the observation stays pending then.
+// Fetched card is recorded regardless of ctx.
+func parseProduct(ctx context.Context, db *sqlx.DB, client MarketplaceClient, run *CrawlRun, o *observation) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+	l := logger.FromContext(ctx).With("portal_id", o.PortalID, "observation_id", o.ID)
+	ctx = logger.NewContext(ctx, l)
 
-	resp, err := client.Do(req)
+	pending, err := o.pending(ctx, db)
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		l.With("error", err).Errorf("load observation failed")
+		return err
+	}
+	if !pending {
+		l.Warnf("observation is already parsed")
+		return nil
 	}
 
-	p := &ProductResponse{}
-
-	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(p)
+	p, err := client.FetchProduct(ctx, o.PortalID)
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		l.With("error", err).Errorf("fetch product failed")
+		run.productFailed()
+		wctx, cancel := detachedContext(ctx)
+		defer cancel()
+		if err := o.saveFetchError(wctx, db, err); err != nil {
+			l.With("error", err).Errorf("save fetch error failed")
+		}
+		if !IsPermanent(err) {
+			return err
+		}
+		return nil
 	}
-	resp.Body.Close()
+	l.Debugf("product fetched, %d SKUs, %d characteristics", len(p.SkuList), len(p.Characteristics))
 
-	if p.Error != "" {
-		return nil, errors.New(p.Error)
+	wctx, cancel := detachedContext(ctx)
+	defer cancel()
+	unchanged, err := p.observe(wctx, db, o)
+	if err != nil {
+		l.With("error", err).Errorf("save product failed")
+		run.productFailed()
+		return err
+	}
+	if unchanged {
+		l.Debugf("product is unchanged, fingerprint %s", p.Fingerprint)
+		run.productUnchanged()
+		return nil
 	}
 
-	return p.Payload.Data, nil
+	run.productParsed()
+	l.Debugf("product parsed")
+	return nil
 }
@@ -1,16 +1,14 @@
 package service
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"math"
-	"math/rand"
-	"net/http"
 	"sync"
 	"time"
 
+	"github.com/isqad/kexpress/internal/logger"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -41,112 +39,182 @@ type ProductOfList struct {
 	SessionID        int64     `json:"-" db:"session_id"`
 }
 
-func (p *ProductListResponse) saveProducts(db *sqlx.DB) error {
+// saveProducts saves listed products and returns number of products
+// not observed in the session yet
+func (p *ProductListResponse) saveProducts(ctx context.Context, db *sqlx.DB) (int64, error) {
 	if p.Error != "" {
-		return errors.New(p.Error)
+		return 0, errors.New(p.Error)
 	}
 
-	tx := db.MustBegin()
+	var saved int64
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
 	for _, p := range p.Payload.Products {
-		_, err := tx.NamedExec(`INSERT INTO products (portal_id, title, portal_category_id, category_id, rating, session_id, created_at)
-		VALUES (:portal_id, :title, :portal_category_id, :category_id, :rating, :session_id, NOW())
-		ON CONFLICT ON CONSTRAINT uniq_portal_id_session_id_products DO NOTHING`, p)
-
+		_, created, err := upsertProduct(ctx, tx, p)
 		if err != nil {
 			tx.Rollback()
-			return err
+			return 0, err
+		}
+		if created {
+			saved++
 		}
 	}
-	return tx.Commit()
+	return saved, tx.Commit()
 }
 
-// CrawlProductList crawls product listings
-func CrawlProductList(db *sqlx.DB, rootCategoryID int64) error {
-	sessionID := time.Now().UnixNano()
+// CrawlProductList crawls product listings.
+// Failed categories don't stop the rest, the run fails with their error after all are crawled.
+func CrawlProductList(ctx context.Context, db *sqlx.DB, client MarketplaceClient, rootCategoryID int64, opts CrawlOptions) (err error) {
 	var wg sync.WaitGroup
-	workerPoolSize := 100
+	workerPoolSize := opts.workers()
+
+	sessionID, resumed, err := opts.session(ctx, db, rootCategoryID, StageListing)
+	if err != nil {
+		return err
+	}
+
+	run, err := startCrawlRun(ctx, db, opts.job(), StageListing, rootCategoryID, sessionID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if ferr := run.finish(err); ferr != nil {
+			run.log.With("error", ferr).Errorf("finish crawl run")
+		}
+	}()
+	ctx = run.context(ctx)
 
 	dataCh := make(chan *Category, workerPoolSize)
 
-	leaves, err := CategoryLeaves(db, rootCategoryID)
+	leaves, err := opts.leaves(ctx, db, rootCategoryID)
+	if err != nil {
+		return err
+	}
+	checkpoints, err := stageCheckpoints(ctx, db, sessionID, StageListing, resumed)
 	if err != nil {
 		return err
 	}
+	var failures categoryFailures
+	pool := newWorkerPool(StageListing, workerPoolSize)
+	defer pool.close()
 	for i := 0; i < workerPoolSize; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for category := range dataCh {
-				log.Printf("INFO: got category %d to load\n", category.ID)
 				cid := category.ID
 				pid := category.PortalID
 				amount := category.ProductAmount
 				totalProducts := amount
 				totalPages := int(math.Ceil(float64(totalProducts) / float64(perPage)))
-				log.Printf("INFO: category: %d, Total products: %d, Total pages: %d\n", cid, totalProducts, totalPages)
+				l := run.log.With("category_id", cid)
+				l.Infof("load category, %d products on %d pages", totalProducts, totalPages)
+
+				cp, ok := checkpoints[cid]
+				if !ok {
+					cp = newCheckpoint(sessionID, StageListing, cid)
+				}
+				if cp.Done {
+					l.Infof("category already loaded in session")
+					continue
+				}
 
-				if err := loadProductList(db, sessionID, 0, pid, cid, totalPages); err != nil {
-					log.Printf("ERROR: Error loading product list for category: #%d\n", cid)
-					return
+				done := pool.acquire()
+				err := loadProductList(logger.NewContext(ctx, l), db, client, run, cp, pid, totalPages)
+				done()
+				if err != nil {
+					l.With("error", err).Errorf("load category failed")
+					if ctx.Err() == nil {
+						failures.add(cid, err)
+					}
+					continue
 				}
-				log.Printf("INFO: category %d loaded successfully!\n", category.ID)
+				l.Infof("category loaded")
 			}
 		}()
 	}
 
+dispatch:
 	for _, category := range leaves {
-		dataCh <- category
+		select {
+		case dataCh <- category:
+		case <-ctx.Done():
+			run.log.Infof("listing interrupted")
+			break dispatch
+		}
 	}
 	close(dataCh)
 
 	wg.Wait()
 
-	return nil
-}
-
-func loadProductList(db *sqlx.DB, sessID int64, page int, portalCategoryID int64, categoryID int64, totalPages int) error {
-	log.Printf("Parse listing page: %d\n", page)
-
-	if totalPages > 0 && page > totalPages {
-		log.Println("All pages parsed and saved!")
-		return nil
-	}
-
-	url := fmt.Sprintf("https://api.kazanexpress.ru/api/v2/main/search/product?size=%d&page=%d&categoryId=%d&sortBy=orders&order=descending", perPage, page, portalCategoryID)
-	client := &http.Client{
-		Timeout: 120 * time.Second,
-	}
-	req, err := newRequest(url)
-
-	resp, err := client.Do(req)
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	return failures.err()
+}
 
-	pResponse := &ProductListResponse{}
+// loadProductList loads listing pages of category following the checkpoint.
+// Failed pages are skipped, so one bad page doesn't abandon the rest of category.
+// The checkpoint moves past pages failed permanently only, it stays before the first
+// page failed transiently and the category is left unfinished, so resumed run retries it.
+// Loading stops between pages when ctx is done, the checkpoint keeps the last loaded page.
+func loadProductList(ctx context.Context, db *sqlx.DB, client MarketplaceClient, run *CrawlRun, cp *checkpoint, portalCategoryID int64, totalPages int) error {
+	l := logger.FromContext(ctx)
+	retryPage := -1
+	for page := cp.LastPage + 1; totalPages == 0 || page <= totalPages; page++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.Debugf("load listing page %d", page)
 
-	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(pResponse)
-	if err != nil {
-		return err
-	}
-	resp.Body.Close()
+		pResponse, err := client.FetchProductList(ctx, portalCategoryID, page)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			l.With("error", err, "page", page).Errorf("listing page failed")
+			run.pageFailed()
+			if totalPages == 0 {
+				return err
+			}
+			if !IsPermanent(err) && retryPage < 0 {
+				retryPage = page
+			}
+		} else {
+			if pResponse.Payload == nil || len(pResponse.Payload.Products) == 0 {
+				break
+			}
+			for _, p := range pResponse.Payload.Products {
+				p.CategoryID = cp.CategoryID
+				p.SessionID = cp.SessionID
+			}
 
-	if pResponse.Payload == nil || len(pResponse.Payload.Products) == 0 {
-		return nil
-	}
-	for _, p := range pResponse.Payload.Products {
-		p.CategoryID = categoryID
-		p.SessionID = sessID
-	}
+			saved, err := pResponse.saveProducts(ctx, db)
+			if err != nil {
+				return err
+			}
+			run.pageFetched(saved)
+			l.Debugf("listing page %d saved, %d new products", page, saved)
+		}
+		if retryPage >= 0 {
+			continue
+		}
 
-	err = pResponse.saveProducts(db)
-	if err != nil {
-		return err
+		// loaded page is recorded even if ctx is done meanwhile
+		cp.LastPage = page
+		wctx, cancel := detachedContext(ctx)
+		err = cp.save(wctx, db)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	if retryPage >= 0 {
+		return fmt.Errorf("listing page %d failed, category is left unfinished", retryPage)
 	}
-	timeout := rand.Intn(7)
-	log.Printf("Page %d has been parsed. Sleep %ds\n", page, timeout)
-	time.Sleep(time.Duration(timeout) * time.Second)
 
-	return loadProductList(db, sessID, page+1, portalCategoryID, categoryID, totalPages)
+	cp.Done = true
+	return cp.save(ctx, db)
 }
@@ -0,0 +1,361 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrInvalidCursor is returned when pagination cursor can not be decoded
+// or was issued for another sorting
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ProductItem is parsed product in products list
+type ProductItem struct {
+	PortalID             int64     `json:"id" db:"portal_id"`
+	Title                string    `json:"title" db:"title"`
+	CategoryID           int64     `json:"categoryId" db:"category_id"`
+	CategoryTitle        string    `json:"categoryTitle" db:"category_title"`
+	SellerID             int64     `json:"sellerId" db:"seller_id"`
+	SellerTitle          string    `json:"sellerTitle" db:"seller_title"`
+	OrdersAmount         int       `json:"ordersAmount" db:"orders_amount"`
+	ReviewsAmount        int       `json:"reviewsAmount" db:"reviews_amount"`
+	TotalAvailableAmount int       `json:"totalAvailableAmount" db:"total_available_amount"`
+	Rating               float64   `json:"rating" db:"rating"`
+	Price                float64   `json:"price" db:"price"`
+	ParsedAt             time.Time `json:"parsedAt" db:"parsed_at"`
+}
+
+// productItemSort maps sort keys of products list to columns
+var productItemSort = map[string]string{
+	"id":      "portal_id",
+	"orders":  "orders_amount",
+	"reviews": "reviews_amount",
+	"rating":  "rating",
+	"price":   "price",
+	"stock":   "total_available_amount",
+}
+
+// IsProductSort reports whether products list can be sorted by the key
+func IsProductSort(key string) bool {
+	_, ok := productItemSort[key]
+	return ok
+}
+
+// ProductFilter are filters, sorting and page of products list
+type ProductFilter struct {
+	// CategoryID limits products to the category subtree
+	CategoryID int64
+	SellerID   int64
+	MinPrice   float64
+	MaxPrice   float64
+	MinRating  float64
+	MinOrders  int
+	InStock    bool
+	Sort       string
+	Desc       bool
+	// Cursor is NextCursor of the previous page
+	Cursor string
+	Limit  int
+}
+
+// ProductPage is page of products list
+type ProductPage struct {
+	Products   []*ProductItem `json:"products"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// productCursor is position after the last product of page in the sorting
+type productCursor struct {
+	sort     string
+	desc     bool
+	value    float64
+	portalID int64
+}
+
+func (c *productCursor) encode() string {
+	raw := fmt.Sprintf("%s|%t|%s|%d", c.sort, c.desc, strconv.FormatFloat(c.value, 'g', -1, 64), c.portalID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeProductCursor(s string) (*productCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 4 {
+		return nil, ErrInvalidCursor
+	}
+
+	c := &productCursor{sort: parts[0]}
+	if c.desc, err = strconv.ParseBool(parts[1]); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.value, err = strconv.ParseFloat(parts[2], 64); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.portalID, err = strconv.ParseInt(parts[3], 10, 64); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// sortValue returns value of the product the list is sorted by
+func (p *ProductItem) sortValue(sort string) float64 {
+	switch sort {
+	case "orders":
+		return float64(p.OrdersAmount)
+	case "reviews":
+		return float64(p.ReviewsAmount)
+	case "rating":
+		return p.Rating
+	case "price":
+		return p.Price
+	case "stock":
+		return float64(p.TotalAvailableAmount)
+	}
+	return float64(p.PortalID)
+}
+
+// Products returns page of parsed products matching the filter
+func Products(ctx context.Context, db *sqlx.DB, f ProductFilter) (*ProductPage, error) {
+	column, ok := productItemSort[f.Sort]
+	if !ok {
+		f.Sort = "orders"
+		column = productItemSort[f.Sort]
+	}
+
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where := []string{"p.parsed_at IS NOT NULL"}
+	if f.CategoryID != 0 {
+		where = append(where, `p.category_id IN (WITH RECURSIVE subtree AS (
+		    SELECT id FROM categories WHERE id = `+arg(f.CategoryID)+`
+		    UNION ALL
+		    SELECT categories.id FROM subtree JOIN categories ON categories.parent_id = subtree.id
+		  ) SELECT id FROM subtree)`)
+	}
+	if f.SellerID != 0 {
+		where = append(where, "p.seller_id = "+arg(f.SellerID))
+	}
+
+	if f.MinRating > 0 {
+		where = append(where, "p.rating >= "+arg(f.MinRating))
+	}
+	if f.MinOrders > 0 {
+		where = append(where, "p.orders_amount >= "+arg(f.MinOrders))
+	}
+	if f.InStock {
+		where = append(where, "p.total_available_amount > 0")
+	}
+
+	// price is computed from SKUs, so products are filtered and paged by it
+	// only if the list is sorted or filtered by price. Otherwise the page is
+	// selected by columns of products and price is computed for it only.
+	byPrice := f.Sort == "price" || f.MinPrice > 0 || f.MaxPrice > 0
+	having := []string{"TRUE"}
+	if f.MinPrice > 0 {
+		having = append(having, "price >= "+arg(f.MinPrice))
+	}
+	if f.MaxPrice > 0 {
+		having = append(having, "price <= "+arg(f.MaxPrice))
+	}
+
+	op, order := ">", "ASC"
+	if f.Desc {
+		op, order = "<", "DESC"
+	}
+	if f.Cursor != "" {
+		c, err := decodeProductCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.sort != f.Sort || c.desc != f.Desc {
+			return nil, ErrInvalidCursor
+		}
+		if f.Sort == "price" {
+			having = append(having, fmt.Sprintf("(price, portal_id) %s (%s::float8, %s)",
+				op, arg(c.value), arg(c.portalID)))
+		} else {
+			where = append(where, fmt.Sprintf("(p.%s::float8, p.portal_id) %s (%s::float8, %s)",
+				column, op, arg(c.value), arg(c.portalID)))
+		}
+	}
+
+	limit := f.Limit
+	if limit < 1 {
+		limit = 50
+	}
+
+	columns := `p.portal_id,
+	  COALESCE(p.title, '') AS title,
+	  COALESCE(p.category_id, 0) AS category_id,
+	  COALESCE(p.category_title, '') AS category_title,
+	  COALESCE(p.seller_id, 0) AS seller_id,
+	  COALESCE(p.seller_title, '') AS seller_title,
+	  p.orders_amount,
+	  p.reviews_amount,
+	  p.total_available_amount,
+	  p.rating::float8 AS rating,
+	  COALESCE((SELECT MIN(skus.purchase_price) FROM skus WHERE skus.product_id = p.id), 0)::float8 AS price,
+	  p.parsed_at`
+	orderBy := ` ORDER BY ` + column + ` ` + order + `, portal_id ` + order
+
+	var query string
+	if byPrice {
+		query = `SELECT * FROM (
+		    SELECT ` + columns + `
+		    FROM products p
+		    WHERE ` + strings.Join(where, " AND ") + `
+		  ) parsed_products
+		  WHERE ` + strings.Join(having, " AND ") + orderBy + `
+		  LIMIT ` + arg(limit+1)
+	} else {
+		query = `SELECT ` + columns + `
+		  FROM (
+		    SELECT p.id, p.portal_id, p.title, p.category_id, p.category_title, p.seller_id, p.seller_title,
+		      p.orders_amount, p.reviews_amount, p.total_available_amount, p.rating, p.parsed_at
+		    FROM products p
+		    WHERE ` + strings.Join(where, " AND ") + `
+		    ORDER BY p.` + column + ` ` + order + `, p.portal_id ` + order + `
+		    LIMIT ` + arg(limit+1) + `
+		  ) p` + orderBy
+	}
+
+	page := &ProductPage{Products: []*ProductItem{}}
+	if err := db.SelectContext(ctx, &page.Products, query, args...); err != nil {
+		return nil, err
+	}
+
+	if len(page.Products) > limit {
+		page.Products = page.Products[:limit]
+		last := page.Products[limit-1]
+		c := &productCursor{sort: f.Sort, desc: f.Desc, value: last.sortValue(f.Sort), portalID: last.PortalID}
+		page.NextCursor = c.encode()
+	}
+
+	return page, nil
+}
+
+// ProductDetails is product in the card format with its observations
+// and price history of its SKUs
+type ProductDetails struct {
+	Product      *Product              `json:"product"`
+	History      []*ProductObservation `json:"history"`
+	PriceHistory []*SkuSnapshot        `json:"priceHistory"`
+}
+
+// skuCharValue is characteristic value of SKU of the product
+type skuCharValue struct {
+	SkuID       int64  `db:"sku_id"`
+	CharID      int64  `db:"char_id"`
+	CharTitle   string `db:"char_title"`
+	CharValueID int64  `db:"char_value_id"`
+	Title       string `db:"title"`
+	Value       string `db:"value"`
+}
+
+// FindProductDetails fetches parsed product by portal ID with characteristics,
+// SKUs, observations and price history of last period days
+func FindProductDetails(ctx context.Context, db *sqlx.DB, portalID int64, period int) (*ProductDetails, error) {
+	p := &Product{}
+	err := db.GetContext(ctx, p, `SELECT id, portal_id, COALESCE(title, '') AS title, description, portal_category_id,
+	    category_id, category_title, seller_id, seller_title, orders_amount, reviews_amount,
+	    total_available_amount, rating, created_at, fingerprint, session_id
+	  FROM products
+	  WHERE portal_id = $1 AND parsed_at IS NOT NULL`, portalID)
+	if err != nil {
+		return nil, err
+	}
+
+	if c, err := findCategory(ctx, db, p.CategoryID); err == nil {
+		p.Category = c
+	}
+	if p.SellerID != nil {
+		p.Seller = &Seller{PortalID: *p.SellerID}
+		if p.SellerTitle != nil {
+			p.Seller.Title = *p.SellerTitle
+		}
+		if s, err := FindSeller(ctx, db, *p.SellerID); err == nil {
+			p.Seller = s
+		}
+	}
+
+	p.SkuList = []*Sku{}
+	if err := db.SelectContext(ctx, &p.SkuList, `SELECT id, COALESCE(portal_id, 0) AS portal_id, product_id,
+	    available_amount, full_price, purchase_price
+	  FROM skus WHERE product_id = $1 ORDER BY id`, p.ID); err != nil {
+		return nil, err
+	}
+
+	values := []*skuCharValue{}
+	if err := db.SelectContext(ctx, &values, `SELECT scv.sku_id, c.id AS char_id, c.title AS char_title,
+	    cv.id AS char_value_id, cv.title, cv.value
+	  FROM sku_char_values scv
+	  JOIN skus ON skus.id = scv.sku_id
+	  JOIN char_values cv ON cv.id = scv.char_value_id
+	  JOIN characteristics c ON c.id = cv.char_id
+	  WHERE skus.product_id = $1
+	  ORDER BY c.id, cv.id`, p.ID); err != nil {
+		return nil, err
+	}
+	p.setCharacteristics(values)
+
+	d := &ProductDetails{Product: p}
+	to := time.Now()
+	from := to.AddDate(0, 0, -period)
+	if d.History, err = ProductHistory(ctx, db, portalID, from, to); err != nil {
+		return nil, err
+	}
+	if d.PriceHistory, err = ProductSkuHistory(ctx, db, portalID, from, to); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// setCharacteristics restores characteristics of the product and
+// their indexes in SKUs as they are given in product card
+func (p *Product) setCharacteristics(values []*skuCharValue) {
+	p.Characteristics = []*Characteristic{}
+	charIndex := make(map[int64]int)
+	valueIndex := make(map[int64]int)
+	skus := make(map[int64]*Sku, len(p.SkuList))
+	for _, s := range p.SkuList {
+		s.Characteristics = []*SkuCharacteristic{}
+		skus[s.ID] = s
+	}
+
+	for _, v := range values {
+		ci, ok := charIndex[v.CharID]
+		if !ok {
+			ci = len(p.Characteristics)
+			charIndex[v.CharID] = ci
+			p.Characteristics = append(p.Characteristics, &Characteristic{ID: v.CharID, Title: v.CharTitle})
+		}
+		c := p.Characteristics[ci]
+
+		vi, ok := valueIndex[v.CharValueID]
+		if !ok {
+			vi = len(c.Values)
+			valueIndex[v.CharValueID] = vi
+			c.Values = append(c.Values, &CharValue{ID: v.CharValueID, CharID: v.CharID, Title: v.Title, Value: v.Value})
+		}
+
+		if s, ok := skus[v.SkuID]; ok {
+			s.Characteristics = append(s.Characteristics, &SkuCharacteristic{CharIndex: ci, ValueIndex: vi})
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestProductCursor(t *testing.T) {
+	tests := []*productCursor{
+		{sort: "orders", desc: true, value: 1500, portalID: 42},
+		{sort: "rating", value: 4.7, portalID: 1},
+		{sort: "price", desc: true, value: 1234.56, portalID: 987654321},
+		{sort: "id", value: 0, portalID: 0},
+		{sort: "stock", value: -1, portalID: 5},
+	}
+
+	for _, c := range tests {
+		got, err := decodeProductCursor(c.encode())
+		if err != nil {
+			t.Errorf("decodeProductCursor(%+v) error = %v", c, err)
+			continue
+		}
+		if *got != *c {
+			t.Errorf("decodeProductCursor() = %+v, want %+v", got, c)
+		}
+	}
+}
+
+func TestDecodeProductCursorInvalid(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "not base64", cursor: "!!!"},
+		{name: "too few parts", cursor: encode("orders|true|1")},
+		{name: "too many parts", cursor: encode("orders|true|1|2|3")},
+		{name: "bad direction", cursor: encode("orders|down|1|2")},
+		{name: "bad value", cursor: encode("orders|true|many|2")},
+		{name: "bad portal ID", cursor: encode("orders|true|1|x")},
+		{name: "empty", cursor: encode("")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeProductCursor(tt.cursor); err != ErrInvalidCursor {
+				t.Errorf("decodeProductCursor(%q) error = %v, want %v", tt.cursor, err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestProductItemSortValue(t *testing.T) {
+	p := &ProductItem{PortalID: 7, OrdersAmount: 10, ReviewsAmount: 3, Rating: 4.5, Price: 99.9, TotalAvailableAmount: 20}
+
+	tests := map[string]float64{
+		"orders":  10,
+		"reviews": 3,
+		"rating":  4.5,
+		"price":   99.9,
+		"stock":   20,
+		"id":      7,
+	}
+
+	for sort, want := range tests {
+		if !IsProductSort(sort) {
+			t.Errorf("IsProductSort(%q) = false", sort)
+		}
+		if got := p.sortValue(sort); got != want {
+			t.Errorf("sortValue(%q) = %v, want %v", sort, got, want)
+		}
+	}
+}
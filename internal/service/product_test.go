@@ -0,0 +1,44 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCalcFingerprintWithoutDescription(t *testing.T) {
+	var missing, empty Product
+	if err := json.Unmarshal([]byte(`{"id":100,"description":null,"skuList":[{"id":1,"purchasePrice":10}]}`), &missing); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"id":100,"description":"","skuList":[{"id":1,"purchasePrice":10}]}`), &empty); err != nil {
+		t.Fatal(err)
+	}
+
+	missing.calcFingerprint()
+	empty.calcFingerprint()
+	if missing.Fingerprint == "" || missing.Fingerprint != empty.Fingerprint {
+		t.Errorf("fingerprint without description = %q, want %q of empty description", missing.Fingerprint, empty.Fingerprint)
+	}
+}
+
+func TestPrice(t *testing.T) {
+	tests := []struct {
+		name   string
+		prices []float32
+		want   float32
+	}{
+		{name: "no SKUs", want: 0},
+		{name: "one SKU", prices: []float32{120}, want: 120},
+		{name: "lowest SKU", prices: []float32{300, 90, 150}, want: 90},
+	}
+
+	for _, tt := range tests {
+		p := &Product{}
+		for _, price := range tt.prices {
+			p.SkuList = append(p.SkuList, &Sku{PurchasePrice: price})
+		}
+		if got := p.price(); got != tt.want {
+			t.Errorf("%s: price() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy describes how failed upstream requests are retried
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is used by client when no policy given
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+}
+
+// delay returns pause before the next attempt: exponential backoff with full jitter,
+// but not shorter than Retry-After of the failed response
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	d := p.BaseDelay << uint(attempt)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d > 0 {
+		d = time.Duration(rand.Int63n(int64(d)))
+	}
+	if retryAfter > d {
+		return retryAfter
+	}
+
+	return d
+}
+
+// FetchError is failed upstream request
+type FetchError struct {
+	URL        string
+	StatusCode int
+	Attempts   int
+	Permanent  bool
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	kind := "transient"
+	if e.Permanent {
+		kind = "permanent"
+	}
+	if e.StatusCode > 0 {
+		return fmt.Sprintf("%s error fetching %s after %d attempt(s): status %d: %v",
+			kind, e.URL, e.Attempts, e.StatusCode, e.Err)
+	}
+
+	return fmt.Sprintf("%s error fetching %s after %d attempt(s): %v", kind, e.URL, e.Attempts, e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent reports whether err will not go away on retry
+func IsPermanent(err error) bool {
+	var fe *FetchError
+	if errors.As(err, &fe) {
+		return fe.Permanent
+	}
+
+	return false
+}
+
+// fetchAttempts returns number of attempts made before err
+func fetchAttempts(err error) int {
+	var fe *FetchError
+	if errors.As(err, &fe) {
+		return fe.Attempts
+	}
+
+	return 1
+}
+
+// classifyResponse returns error for non successful response
+func classifyResponse(resp *http.Response) *FetchError {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+
+	fe := &FetchError{
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Err:        errors.New(http.StatusText(resp.StatusCode)),
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		fe.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode >= 500:
+	default:
+		fe.Permanent = true
+	}
+
+	return fe
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(v); err == nil {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
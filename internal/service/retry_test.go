@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClassifyResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		wantErr    bool
+		permanent  bool
+		wantAfter  time.Duration
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "no content", status: http.StatusNoContent},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+		{name: "bad gateway", status: http.StatusBadGateway, wantErr: true},
+		{name: "not found", status: http.StatusNotFound, wantErr: true, permanent: true},
+		{name: "forbidden", status: http.StatusForbidden, wantErr: true, permanent: true},
+		{name: "rate limited", status: http.StatusTooManyRequests, wantErr: true},
+		{name: "rate limited with seconds", status: http.StatusTooManyRequests, retryAfter: "7", wantErr: true, wantAfter: 7 * time.Second},
+		{name: "rate limited with garbage", status: http.StatusTooManyRequests, retryAfter: "soon", wantErr: true},
+		{name: "retry after ignored for server error", status: http.StatusServiceUnavailable, retryAfter: "7", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{},
+				Request:    &http.Request{URL: &url.URL{Scheme: "http", Host: "api", Path: "/p"}},
+			}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+
+			fe := classifyResponse(resp)
+			if !tt.wantErr {
+				if fe != nil {
+					t.Fatalf("classifyResponse() = %v, want nil", fe)
+				}
+				return
+			}
+			if fe == nil {
+				t.Fatal("classifyResponse() = nil, want error")
+			}
+			if fe.StatusCode != tt.status {
+				t.Errorf("StatusCode = %d, want %d", fe.StatusCode, tt.status)
+			}
+			if fe.Permanent != tt.permanent {
+				t.Errorf("Permanent = %t, want %t", fe.Permanent, tt.permanent)
+			}
+			if fe.RetryAfter != tt.wantAfter {
+				t.Errorf("RetryAfter = %s, want %s", fe.RetryAfter, tt.wantAfter)
+			}
+			if IsPermanent(fe) != tt.permanent {
+				t.Errorf("IsPermanent() = %t, want %t", IsPermanent(fe), tt.permanent)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfterDate(t *testing.T) {
+	at := time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)
+	d := parseRetryAfter(at)
+	if d < 28*time.Second || d > 30*time.Second {
+		t.Errorf("parseRetryAfter(%q) = %s, want about 30s", at, d)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		attempt    int
+		retryAfter time.Duration
+		min        time.Duration
+		max        time.Duration
+	}{
+		{name: "first attempt", policy: p, attempt: 0, max: 100 * time.Millisecond},
+		{name: "backoff doubles", policy: p, attempt: 2, max: 400 * time.Millisecond},
+		{name: "capped by max delay", policy: p, attempt: 10, max: time.Second},
+		{name: "shift overflow capped", policy: p, attempt: 70, max: time.Second},
+		{name: "retry after is minimum", policy: p, attempt: 0, retryAfter: 5 * time.Second, min: 5 * time.Second, max: 5 * time.Second},
+		{name: "retry after shorter than backoff", policy: p, attempt: 3, retryAfter: time.Nanosecond, min: time.Nanosecond, max: 800 * time.Millisecond},
+		{name: "zero policy", policy: RetryPolicy{}, attempt: 3},
+		{name: "zero policy with retry after", policy: RetryPolicy{}, attempt: 3, retryAfter: time.Second, min: time.Second, max: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				d := tt.policy.delay(tt.attempt, tt.retryAfter)
+				if d < tt.min || d > tt.max {
+					t.Fatalf("delay(%d, %s) = %s, want in [%s, %s]", tt.attempt, tt.retryAfter, d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int32
+		wantErr      bool
+		permanent    bool
+	}{
+		{name: "success", statuses: []int{200}, wantAttempts: 1},
+		{name: "transient then success", statuses: []int{500, 429, 200}, wantAttempts: 3},
+		{name: "permanent is not retried", statuses: []int{404}, wantAttempts: 1, wantErr: true, permanent: true},
+		{name: "attempts exhausted", statuses: []int{500, 500, 500, 500}, wantAttempts: 3, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				status := tt.statuses[len(tt.statuses)-1]
+				if int(n) <= len(tt.statuses) {
+					status = tt.statuses[n-1]
+				}
+				if status != http.StatusOK {
+					w.WriteHeader(status)
+					return
+				}
+				w.Write([]byte(`{"payload": {"data": {"id": 42, "title": "Product"}}}`))
+			}))
+			defer srv.Close()
+
+			c := NewKazanExpressClient(ClientOptions{
+				BaseURL: srv.URL,
+				Retry:   RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			})
+			p, err := c.FetchProduct(context.Background(), 42)
+
+			if got := atomic.LoadInt32(&calls); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("FetchProduct() error = %v", err)
+				}
+				if p.PortalID != 42 {
+					t.Errorf("PortalID = %d, want 42", p.PortalID)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("FetchProduct() error = nil, want error")
+			}
+			if IsPermanent(err) != tt.permanent {
+				t.Errorf("IsPermanent() = %t, want %t", IsPermanent(err), tt.permanent)
+			}
+			if got := fetchAttempts(err); got != int(tt.wantAttempts) {
+				t.Errorf("fetchAttempts() = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestClientStopsRetryingOnCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c := NewKazanExpressClient(ClientOptions{
+		BaseURL: srv.URL,
+		Retry:   RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.FetchProduct(ctx, 42)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FetchProduct() error = %v, want deadline exceeded", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("FetchProduct() took %s after cancel", time.Since(start))
+	}
+}
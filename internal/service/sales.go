@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/isqad/kexpress/internal/logger"
+	"github.com/jmoiron/sqlx"
+)
+
+// salesLookback is how far before the estimated period previous observation is searched
+const salesLookback = 30 * 24 * time.Hour
+
+// ProductObservation is state of product observed in crawl session
+type ProductObservation struct {
+	PortalID             int64   `json:"productId" db:"portal_id"`
+	CategoryID           int64   `json:"categoryId" db:"category_id"`
+	SellerID             int64   `json:"sellerId" db:"seller_id"`
+	OrdersAmount         int     `json:"ordersAmount" db:"orders_amount"`
+	TotalAvailableAmount int     `json:"totalAvailableAmount" db:"total_available_amount"`
+	Price                float64 `json:"price" db:"price"`
+	// Unchanged is set if the product card was the same as in the previous observation
+	Unchanged  bool      `json:"unchanged" db:"unchanged"`
+	ObservedAt time.Time `json:"observedAt" db:"observed_at"`
+}
+
+// DailySales is estimated sales of product for one day
+type DailySales struct {
+	Day             time.Time `json:"day" db:"day"`
+	ProductPortalID int64     `json:"productId" db:"product_portal_id"`
+	CategoryID      int64     `json:"categoryId" db:"category_id"`
+	SellerID        int64     `json:"sellerId" db:"seller_id"`
+	Units           float64   `json:"units" db:"units"`
+	Price           float64   `json:"price" db:"price"`
+	Revenue         float64   `json:"revenue" db:"revenue"`
+}
+
+// ordersRounded reports whether orders amount looks rounded by marketplace.
+// Large amounts are shown rounded to hundreds, so their delta is not reliable.
+func ordersRounded(orders int) bool {
+	return orders >= 1000 && orders%100 == 0
+}
+
+// unitsSold estimates units sold between two consecutive observations.
+// Orders delta is used unless orders are rounded, then stock decrease is used.
+// Restocks hide sales, so stock increase is not counted.
+func unitsSold(prev *ProductObservation, cur *ProductObservation) int {
+	orders := cur.OrdersAmount - prev.OrdersAmount
+	if orders < 0 {
+		orders = 0
+	}
+	stockDrop := prev.TotalAvailableAmount - cur.TotalAvailableAmount
+
+	if ordersRounded(prev.OrdersAmount) || ordersRounded(cur.OrdersAmount) {
+		if stockDrop > 0 {
+			return stockDrop
+		}
+	}
+
+	return orders
+}
+
+// EstimateSales turns consecutive observations of one product ordered by time
+// into daily sales. Units sold between observations are spread evenly over
+// the days after the previous observation up to the current one.
+func EstimateSales(observations []*ProductObservation) []*DailySales {
+	sales := []*DailySales{}
+	byDay := make(map[time.Time]*DailySales)
+
+	for i := 1; i < len(observations); i++ {
+		prev, cur := observations[i-1], observations[i]
+		units := unitsSold(prev, cur)
+		if units == 0 {
+			continue
+		}
+
+		from := truncateDay(prev.ObservedAt)
+		to := truncateDay(cur.ObservedAt)
+		days := int(to.Sub(from).Hours() / 24)
+		if days < 1 {
+			days = 1
+			from = to.AddDate(0, 0, -1)
+		}
+
+		perDay := float64(units) / float64(days)
+		for d := 1; d <= days; d++ {
+			day := from.AddDate(0, 0, d)
+			ds, ok := byDay[day]
+			if !ok {
+				ds = &DailySales{
+					Day:             day,
+					ProductPortalID: cur.PortalID,
+					CategoryID:      cur.CategoryID,
+					SellerID:        cur.SellerID,
+					Price:           cur.Price,
+				}
+				byDay[day] = ds
+				sales = append(sales, ds)
+			}
+			ds.Units += perDay
+			ds.Revenue += perDay * cur.Price
+		}
+	}
+
+	return sales
+}
+
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// EstimateCategorySales estimates daily sales of products of the root category
+// for days between from and to and stores them as daily aggregates
+func EstimateCategorySales(ctx context.Context, db *sqlx.DB, rootCategoryID int64, from time.Time, to time.Time) error {
+	leaves, err := CategoryLeaves(ctx, db, rootCategoryID)
+	if err != nil {
+		return err
+	}
+	categoryIDs := make([]int64, 0, len(leaves))
+	for _, c := range leaves {
+		categoryIDs = append(categoryIDs, c.ID)
+	}
+
+	from = truncateDay(from)
+	to = truncateDay(to)
+
+	query := `SELECT p.portal_id,
+	    o.category_id,
+	    COALESCE(p.seller_id, 0) AS seller_id,
+	    o.orders_amount,
+	    o.total_available_amount,
+	    o.price::float8 AS price,
+	    o.unchanged,
+	    o.observed_at
+	  FROM product_observations o
+	  JOIN products p ON p.id = o.product_id
+	  WHERE o.observed_at IS NOT NULL
+	  AND o.category_id = ANY($1)
+	  AND o.observed_at >= $2 AND o.observed_at < $3
+	  ORDER BY p.portal_id, o.observed_at`
+	rows, err := db.QueryxContext(ctx, query, categoryIDs, from.Add(-salesLookback), to.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var saved int
+	observations := []*ProductObservation{}
+	flush := func() error {
+		for _, ds := range EstimateSales(observations) {
+			if ds.Day.Before(from) || ds.Day.After(to) {
+				continue
+			}
+			if err := ds.save(ctx, db); err != nil {
+				return err
+			}
+			saved++
+		}
+		observations = observations[:0]
+		return nil
+	}
+
+	for rows.Next() {
+		o := &ProductObservation{}
+		if err := rows.StructScan(o); err != nil {
+			return err
+		}
+		if len(observations) > 0 && observations[0].PortalID != o.PortalID {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		observations = append(observations, o)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	logger.FromContext(ctx).With("root_category_id", rootCategoryID).Infof("%d daily sales estimated from %s to %s",
+		saved, from.Format("2006-01-02"), to.Format("2006-01-02"))
+
+	return nil
+}
+
+func (ds *DailySales) save(ctx context.Context, db *sqlx.DB) error {
+	_, err := db.NamedExecContext(ctx, `INSERT INTO product_daily_sales
+	  (day, product_portal_id, category_id, seller_id, units, price, revenue, updated_at)
+	  VALUES (:day, :product_portal_id, :category_id, :seller_id, :units, :price, :revenue, NOW())
+	  ON CONFLICT (day, product_portal_id) DO UPDATE SET
+	    category_id = EXCLUDED.category_id,
+	    seller_id = EXCLUDED.seller_id,
+	    units = EXCLUDED.units,
+	    price = EXCLUDED.price,
+	    revenue = EXCLUDED.revenue,
+	    updated_at = NOW()`, ds)
+	return err
+}
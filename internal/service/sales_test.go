@@ -0,0 +1,133 @@
+package service
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestOrdersRounded(t *testing.T) {
+	tests := []struct {
+		orders int
+		want   bool
+	}{
+		{orders: 0, want: false},
+		{orders: 100, want: false},
+		{orders: 999, want: false},
+		{orders: 1000, want: true},
+		{orders: 1001, want: false},
+		{orders: 1050, want: false},
+		{orders: 1100, want: true},
+		{orders: 25000, want: true},
+	}
+
+	for _, tt := range tests {
+		if got := ordersRounded(tt.orders); got != tt.want {
+			t.Errorf("ordersRounded(%d) = %t, want %t", tt.orders, got, tt.want)
+		}
+	}
+}
+
+func TestUnitsSold(t *testing.T) {
+	tests := []struct {
+		name                  string
+		prevOrders, curOrders int
+		prevStock, curStock   int
+		want                  int
+	}{
+		{name: "orders delta", prevOrders: 10, curOrders: 15, prevStock: 100, curStock: 90, want: 5},
+		{name: "no change", prevOrders: 10, curOrders: 10, prevStock: 100, curStock: 100, want: 0},
+		{name: "orders decreased", prevOrders: 15, curOrders: 10, prevStock: 100, curStock: 100, want: 0},
+		{name: "restock does not affect orders delta", prevOrders: 10, curOrders: 12, prevStock: 5, curStock: 50, want: 2},
+		{name: "rounded orders use stock drop", prevOrders: 1000, curOrders: 1000, prevStock: 100, curStock: 93, want: 7},
+		{name: "previous rounded uses stock drop", prevOrders: 1200, curOrders: 1234, prevStock: 100, curStock: 60, want: 40},
+		{name: "current rounded uses stock drop", prevOrders: 1190, curOrders: 1200, prevStock: 100, curStock: 97, want: 3},
+		{name: "rounded with restock falls back to orders", prevOrders: 1000, curOrders: 1100, prevStock: 10, curStock: 500, want: 100},
+		{name: "rounded without stock change falls back to orders", prevOrders: 1000, curOrders: 1000, prevStock: 10, curStock: 10, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev := &ProductObservation{OrdersAmount: tt.prevOrders, TotalAvailableAmount: tt.prevStock}
+			cur := &ProductObservation{OrdersAmount: tt.curOrders, TotalAvailableAmount: tt.curStock}
+			if got := unitsSold(prev, cur); got != tt.want {
+				t.Errorf("unitsSold() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateSales(t *testing.T) {
+	day := func(d int, hour int) time.Time {
+		return time.Date(2021, 11, d, hour, 0, 0, 0, time.UTC)
+	}
+	obs := func(at time.Time, orders int, price float64) *ProductObservation {
+		return &ProductObservation{PortalID: 1, CategoryID: 2, SellerID: 3, OrdersAmount: orders, TotalAvailableAmount: 100, Price: price, ObservedAt: at}
+	}
+
+	tests := []struct {
+		name         string
+		observations []*ProductObservation
+		want         map[int]float64
+		wantRevenue  float64
+	}{
+		{name: "no observations", want: map[int]float64{}},
+		{name: "single observation", observations: []*ProductObservation{obs(day(1, 3), 10, 100)}, want: map[int]float64{}},
+		{
+			name:         "next day",
+			observations: []*ProductObservation{obs(day(1, 3), 10, 100), obs(day(2, 3), 14, 100)},
+			want:         map[int]float64{2: 4},
+			wantRevenue:  400,
+		},
+		{
+			name:         "spread over gap",
+			observations: []*ProductObservation{obs(day(1, 3), 10, 50), obs(day(4, 3), 16, 50)},
+			want:         map[int]float64{2: 2, 3: 2, 4: 2},
+			wantRevenue:  300,
+		},
+		{
+			name:         "same day observations",
+			observations: []*ProductObservation{obs(day(1, 3), 10, 10), obs(day(1, 20), 13, 10)},
+			want:         map[int]float64{1: 3},
+			wantRevenue:  30,
+		},
+		{
+			name:         "days summed over observations",
+			observations: []*ProductObservation{obs(day(1, 3), 10, 10), obs(day(2, 3), 11, 10), obs(day(2, 20), 13, 10)},
+			want:         map[int]float64{2: 3},
+			wantRevenue:  30,
+		},
+		{
+			name:         "unchanged orders give no sales",
+			observations: []*ProductObservation{obs(day(1, 3), 10, 10), obs(day(2, 3), 10, 10)},
+			want:         map[int]float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sales := EstimateSales(tt.observations)
+			if len(sales) != len(tt.want) {
+				t.Fatalf("EstimateSales() returned %d days, want %d", len(sales), len(tt.want))
+			}
+			var revenue float64
+			for _, s := range sales {
+				want, ok := tt.want[s.Day.Day()]
+				if !ok {
+					t.Errorf("unexpected sales on %s", s.Day.Format("2006-01-02"))
+					continue
+				}
+				if math.Abs(s.Units-want) > 1e-9 {
+					t.Errorf("units on %s = %f, want %f", s.Day.Format("2006-01-02"), s.Units, want)
+				}
+				if s.ProductPortalID != 1 || s.CategoryID != 2 || s.SellerID != 3 {
+					t.Errorf("sales attributed to product %d, category %d, seller %d", s.ProductPortalID, s.CategoryID, s.SellerID)
+				}
+				revenue += s.Revenue
+			}
+			if math.Abs(revenue-tt.wantRevenue) > 1e-9 {
+				t.Errorf("revenue = %f, want %f", revenue, tt.wantRevenue)
+			}
+		})
+	}
+}
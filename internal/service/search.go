@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SearchHit is product matching search query
+type SearchHit struct {
+	PortalID      int64   `json:"id" db:"portal_id"`
+	Title         string  `json:"title" db:"title"`
+	CategoryID    int64   `json:"categoryId" db:"category_id"`
+	CategoryTitle string  `json:"categoryTitle" db:"category_title"`
+	SellerID      int64   `json:"sellerId" db:"seller_id"`
+	SellerTitle   string  `json:"sellerTitle" db:"seller_title"`
+	OrdersAmount  int     `json:"ordersAmount" db:"orders_amount"`
+	Rating        float64 `json:"rating" db:"rating"`
+	Rank          float64 `json:"rank" db:"rank"`
+	// Highlight is HTML escaped title with matched words wrapped in <b> tags
+	Highlight string `json:"highlight" db:"highlight"`
+	// Snippet is HTML escaped fragment of description with matched words wrapped in <b> tags
+	Snippet string `json:"snippet" db:"snippet"`
+}
+
+// SearchFacet is number of products matching search query in category
+type SearchFacet struct {
+	CategoryID int64  `json:"categoryId" db:"category_id"`
+	Title      string `json:"title" db:"title"`
+	Count      int    `json:"count" db:"count"`
+}
+
+// SearchResult is page of products matching search query with category facets
+type SearchResult struct {
+	Total    int            `json:"total"`
+	Products []*SearchHit   `json:"products"`
+	Facets   []*SearchFacet `json:"facets"`
+}
+
+// SearchOptions are query, category filter and page of search
+type SearchOptions struct {
+	Query string
+	// CategoryID limits products to the category, facets are not limited
+	CategoryID int64
+	Limit      int
+	Offset     int
+}
+
+// searchMatches selects products matching query $1
+const searchMatches = `WITH matches AS (
+    SELECT p.id, p.portal_id, COALESCE(p.category_id, 0) AS category_id,
+      ts_rank_cd(p.search_vector, q) AS rank
+    FROM products p, websearch_to_tsquery('russian', $1) q
+    WHERE p.search_vector @@ q
+  )`
+
+// SearchProducts searches products by title and description using Russian morphology.
+// Products are ranked by relevance, facets count matched products per category.
+func SearchProducts(ctx context.Context, db *sqlx.DB, opts SearchOptions) (*SearchResult, error) {
+	limit := opts.Limit
+	if limit < 1 {
+		limit = 50
+	}
+
+	r := &SearchResult{Products: []*SearchHit{}, Facets: []*SearchFacet{}}
+	if err := db.SelectContext(ctx, &r.Facets, searchMatches+`
+	  SELECT m.category_id, trim(both ' ' from COALESCE(c.title, '')) AS title, COUNT(*) AS count
+	  FROM matches m
+	  LEFT JOIN categories c ON c.id = m.category_id
+	  GROUP BY m.category_id, c.title
+	  ORDER BY count DESC, m.category_id`, opts.Query); err != nil {
+		return nil, err
+	}
+
+	for _, f := range r.Facets {
+		if opts.CategoryID == 0 || f.CategoryID == opts.CategoryID {
+			r.Total += f.Count
+		}
+	}
+
+	if err := db.SelectContext(ctx, &r.Products, searchMatches+`
+	  SELECT p.portal_id,
+	    COALESCE(p.title, '') AS title,
+	    m.category_id,
+	    COALESCE(p.category_title, '') AS category_title,
+	    COALESCE(p.seller_id, 0) AS seller_id,
+	    COALESCE(p.seller_title, '') AS seller_title,
+	    p.orders_amount,
+	    p.rating::float8 AS rating,
+	    m.rank::float8 AS rank,
+	    -- text written by sellers is escaped before tags are added
+	    ts_headline('russian',
+	      replace(replace(replace(COALESCE(p.title, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+	      q, 'StartSel=<b>, StopSel=</b>, HighlightAll=true') AS highlight,
+	    ts_headline('russian',
+	      replace(replace(replace(COALESCE(p.description, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+	      q, 'StartSel=<b>, StopSel=</b>, MaxFragments=2') AS snippet
+	  FROM matches m
+	  JOIN products p ON p.id = m.id,
+	    websearch_to_tsquery('russian', $1) q
+	  WHERE ($2::bigint = 0 OR m.category_id = $2)
+	  ORDER BY m.rank DESC, p.orders_amount DESC, p.portal_id
+	  LIMIT $3 OFFSET $4`, opts.Query, opts.CategoryID, limit, opts.Offset); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
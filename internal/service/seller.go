@@ -1,9 +1,307 @@
 package service
 
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Seller is shop on the marketplace
 type Seller struct {
-	PortalID int64   `json:"id"`
-	Title    string  `json:"title"`
-	Orders   int     `json:"orders"`
-	Reviews  int     `json:"reviews"`
-	Rating   float32 `json:"rating"`
+	ID        int64     `json:"-" db:"id"`
+	PortalID  int64     `json:"id" db:"portal_id"`
+	Title     string    `json:"title" db:"title"`
+	Orders    int       `json:"orders" db:"orders"`
+	Reviews   int       `json:"reviews" db:"reviews"`
+	Rating    float32   `json:"rating" db:"rating"`
+	CreatedAt time.Time `json:"-" db:"created_at"`
+	UpdatedAt time.Time `json:"-" db:"updated_at"`
+}
+
+// SellerSnapshot is rating, orders and reviews of seller observed in crawl session
+type SellerSnapshot struct {
+	SellerPortalID int64     `json:"sellerId" db:"seller_portal_id"`
+	SessionID      int64     `json:"sessionId" db:"session_id"`
+	Orders         int       `json:"orders" db:"orders"`
+	Reviews        int       `json:"reviews" db:"reviews"`
+	Rating         float32   `json:"rating" db:"rating"`
+	ObservedAt     time.Time `json:"observedAt" db:"observed_at"`
+}
+
+// SalesTotal is estimated sales summed up for one day
+type SalesTotal struct {
+	Day     time.Time `json:"day" db:"day"`
+	Units   float64   `json:"units" db:"units"`
+	Revenue float64   `json:"revenue" db:"revenue"`
+}
+
+// saveSeller upserts seller of the product and records its snapshot in the product session.
+// Sellers without portal ID are skipped.
+func (p *Product) saveSeller(ctx context.Context, tx *sqlx.Tx) error {
+	s := p.Seller
+	if s == nil || s.PortalID == 0 {
+		return nil
+	}
+
+	if _, err := tx.NamedExecContext(ctx, `INSERT INTO sellers (portal_id, title, orders, reviews, rating, created_at, updated_at)
+	  VALUES (:portal_id, :title, :orders, :reviews, :rating, NOW(), NOW())
+	  ON CONFLICT ON CONSTRAINT uniq_portal_id_sellers DO UPDATE SET
+	    title = EXCLUDED.title,
+	    orders = EXCLUDED.orders,
+	    reviews = EXCLUDED.reviews,
+	    rating = EXCLUDED.rating,
+	    updated_at = NOW()`, s); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO seller_snapshots
+	  (seller_portal_id, session_id, orders, reviews, rating, observed_at)
+	  VALUES ($1, $2, $3, $4, $5, NOW())
+	  ON CONFLICT ON CONSTRAINT uniq_seller_portal_id_session_id_seller_snapshots DO UPDATE SET
+	    orders = EXCLUDED.orders,
+	    reviews = EXCLUDED.reviews,
+	    rating = EXCLUDED.rating,
+	    observed_at = EXCLUDED.observed_at`,
+		s.PortalID, p.SessionID, s.Orders, s.Reviews, s.Rating)
+
+	return err
+}
+
+// FindSeller fetches seller by portal ID
+func FindSeller(ctx context.Context, db *sqlx.DB, portalID int64) (*Seller, error) {
+	s := &Seller{}
+	err := db.GetContext(ctx, s, `SELECT id, portal_id, COALESCE(title, '') AS title, orders, reviews, rating, created_at, updated_at
+	  FROM sellers WHERE portal_id = $1`, portalID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// SellerHistory returns rating, orders and reviews series of seller observed between from and to
+func SellerHistory(ctx context.Context, db *sqlx.DB, portalID int64, from time.Time, to time.Time) ([]*SellerSnapshot, error) {
+	history := []*SellerSnapshot{}
+	err := db.SelectContext(ctx, &history, `SELECT seller_portal_id, session_id, orders, reviews, rating, observed_at
+	  FROM seller_snapshots
+	  WHERE seller_portal_id = $1 AND observed_at BETWEEN $2 AND $3
+	  ORDER BY observed_at`, portalID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// SellerTopProducts is number of products in seller profile
+const SellerTopProducts = 20
+
+// SellerProducts returns up to limit parsed products of seller with most orders
+func SellerProducts(ctx context.Context, db *sqlx.DB, portalID int64, limit int) ([]*Product, error) {
+	products := []*Product{}
+	err := db.SelectContext(ctx, &products, `SELECT id, portal_id, title, description, category_id, category_title,
+	    seller_id, seller_title, orders_amount, reviews_amount, total_available_amount, rating,
+	    created_at, session_id
+	  FROM products
+	  WHERE seller_id = $1 AND parsed_at IS NOT NULL
+	  ORDER BY orders_amount DESC, portal_id
+	  LIMIT $2`, portalID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// SellerCategories returns categories seller trades in with number of seller products
+// and seller sales over last period days
+func SellerCategories(ctx context.Context, db *sqlx.DB, portalID int64, period int) ([]*Category, error) {
+	categories := []*Category{}
+	err := db.SelectContext(ctx, &categories, `SELECT c.id, c.portal_id, trim(both ' ' from c.title::text) AS title,
+	    products.products_amount,
+	    COALESCE(sales.proceeds, 0) AS proceeds,
+	    COALESCE(sales.sells_count, 0) AS sells_count
+	  FROM (
+	    SELECT category_id, COUNT(*) AS products_amount
+	    FROM products
+	    WHERE seller_id = $1 AND parsed_at IS NOT NULL
+	    GROUP BY category_id
+	  ) products
+	  JOIN categories c ON c.id = products.category_id
+	  LEFT JOIN (
+	    SELECT category_id, SUM(revenue)::float8 AS proceeds, SUM(units)::float8 AS sells_count
+	    FROM product_daily_sales
+	    WHERE seller_id = $1 AND day > CURRENT_DATE - $2::int
+	    GROUP BY category_id
+	  ) sales ON sales.category_id = c.id
+	  ORDER BY proceeds DESC, products.products_amount DESC`, portalID, period)
+	if err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+// SellerSales returns estimated daily sales of seller between from and to
+func SellerSales(ctx context.Context, db *sqlx.DB, portalID int64, from time.Time, to time.Time) ([]*SalesTotal, error) {
+	sales := []*SalesTotal{}
+	err := db.SelectContext(ctx, &sales, `SELECT day::timestamptz AS day, SUM(units)::float8 AS units, SUM(revenue)::float8 AS revenue
+	  FROM product_daily_sales
+	  WHERE seller_id = $1 AND day BETWEEN $2::date AND $3::date
+	  GROUP BY day
+	  ORDER BY day`, portalID, truncateDay(from), truncateDay(to))
+	if err != nil {
+		return nil, err
+	}
+
+	return sales, nil
+}
+
+// SellerStats is seller with its products and sales over period
+type SellerStats struct {
+	PortalID      int64   `json:"id" db:"portal_id"`
+	Title         string  `json:"title" db:"title"`
+	Orders        int     `json:"orders" db:"orders"`
+	Reviews       int     `json:"reviews" db:"reviews"`
+	Rating        float32 `json:"rating" db:"rating"`
+	ProductsCount int     `json:"productsCount" db:"products_count"`
+	Units         float64 `json:"units" db:"units"`
+	Revenue       float64 `json:"revenue" db:"revenue"`
+}
+
+// sellerStatsSort maps sort keys of seller stats to columns
+var sellerStatsSort = map[string]string{
+	"revenue":       "revenue",
+	"units":         "units",
+	"orders":        "orders",
+	"rating":        "rating",
+	"productsCount": "products_count",
+}
+
+// IsSellerStatsSort reports whether seller stats can be sorted by the key
+func IsSellerStatsSort(key string) bool {
+	_, ok := sellerStatsSort[key]
+	return ok
+}
+
+// SellerListOptions are filters, sorting and page of sellers leaderboard
+type SellerListOptions struct {
+	// RootCategoryID limits products and sales to the root category, all categories if zero
+	RootCategoryID int64
+	// Period is number of last days sales are summed up for
+	Period int
+	Sort   string
+	Desc   bool
+	Limit  int
+	Offset int
+}
+
+// Sellers returns sellers of parsed products ranked by stats
+func Sellers(ctx context.Context, db *sqlx.DB, opts SellerListOptions) ([]*SellerStats, error) {
+	var categoryIDs []int64
+	if opts.RootCategoryID != 0 {
+		leaves, err := CategoryLeaves(ctx, db, opts.RootCategoryID)
+		if err != nil {
+			return nil, err
+		}
+		categoryIDs = make([]int64, 0, len(leaves))
+		for _, c := range leaves {
+			categoryIDs = append(categoryIDs, c.ID)
+		}
+	}
+
+	column, ok := sellerStatsSort[opts.Sort]
+	if !ok {
+		column = "revenue"
+	}
+	order := "ASC"
+	if opts.Desc {
+		order = "DESC"
+	}
+
+	query := `WITH product_sellers AS (
+	    SELECT seller_id, COUNT(*) AS products_count, MAX(seller_title) AS title
+	    FROM products
+	    WHERE parsed_at IS NOT NULL AND seller_id > 0
+	    AND ($1::bigint[] IS NULL OR category_id = ANY($1))
+	    GROUP BY seller_id
+	  ), sales AS (
+	    SELECT seller_id, SUM(units) AS units, SUM(revenue) AS revenue
+	    FROM product_daily_sales
+	    WHERE day > CURRENT_DATE - $2::int
+	    AND ($1::bigint[] IS NULL OR category_id = ANY($1))
+	    GROUP BY seller_id
+	  )
+	  SELECT ps.seller_id AS portal_id,
+	    COALESCE(s.title, ps.title, '') AS title,
+	    COALESCE(s.orders, 0) AS orders,
+	    COALESCE(s.reviews, 0) AS reviews,
+	    COALESCE(s.rating, 0) AS rating,
+	    ps.products_count,
+	    COALESCE(sales.units, 0)::float8 AS units,
+	    COALESCE(sales.revenue, 0)::float8 AS revenue
+	  FROM product_sellers ps
+	  LEFT JOIN sellers s ON s.portal_id = ps.seller_id
+	  LEFT JOIN sales ON sales.seller_id = ps.seller_id
+	  ORDER BY ` + column + ` ` + order + `, ps.seller_id
+	  LIMIT $3 OFFSET $4`
+
+	sellers := []*SellerStats{}
+	if err := db.SelectContext(ctx, &sellers, query, categoryIDs, opts.Period, opts.Limit, opts.Offset); err != nil {
+		return nil, err
+	}
+
+	return sellers, nil
+}
+
+// SellerDetails is seller profile with its categories, top products and trends over period.
+// All products of seller are listed by ProductsURL.
+type SellerDetails struct {
+	Seller        *Seller           `json:"seller"`
+	Categories    []*Category       `json:"categories"`
+	Products      []*Product        `json:"products"`
+	ProductsTotal int               `json:"productsTotal"`
+	ProductsURL   string            `json:"productsUrl"`
+	History       []*SellerSnapshot `json:"history"`
+	Sales         []*SalesTotal     `json:"sales"`
+}
+
+// FindSellerDetails fetches seller profile with data of last period days.
+// Sellers not stored yet are taken from their products.
+func FindSellerDetails(ctx context.Context, db *sqlx.DB, portalID int64, period int) (*SellerDetails, error) {
+	s, err := FindSeller(ctx, db, portalID)
+	if err == sql.ErrNoRows {
+		s = &Seller{PortalID: portalID}
+		err = db.GetContext(ctx, &s.Title, `SELECT COALESCE(seller_title, '') FROM products
+		  WHERE seller_id = $1 AND parsed_at IS NOT NULL
+		  LIMIT 1`, portalID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := &SellerDetails{Seller: s}
+	to := time.Now()
+	from := to.AddDate(0, 0, -period)
+
+	if d.Categories, err = SellerCategories(ctx, db, portalID, period); err != nil {
+		return nil, err
+	}
+	if d.Products, err = SellerProducts(ctx, db, portalID, SellerTopProducts); err != nil {
+		return nil, err
+	}
+	if err = db.GetContext(ctx, &d.ProductsTotal, `SELECT COUNT(*) FROM products
+	  WHERE seller_id = $1 AND parsed_at IS NOT NULL`, portalID); err != nil {
+		return nil, err
+	}
+	if d.History, err = SellerHistory(ctx, db, portalID, from, to); err != nil {
+		return nil, err
+	}
+	if d.Sales, err = SellerSales(ctx, db, portalID, from, to); err != nil {
+		return nil, err
+	}
+
+	return d, nil
 }
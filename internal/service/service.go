@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -44,8 +45,8 @@ var referersPaths = []string{
 	"/category/Malchikam-2811",
 }
 
-func newRequest(url string) (*http.Request, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
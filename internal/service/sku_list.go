@@ -1,10 +1,16 @@
 package service
 
-import "github.com/jmoiron/sqlx"
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
 
 // Sku is a stock keeping unit
 type Sku struct {
 	ID              int64                `json:"-" db:"id"`
+	PortalID        int64                `json:"id" db:"portal_id"`
 	ProductID       int64                `json:"-" db:"product_id"`
 	CharValueID     *int64               `json:"-" db:"char_value_id"`
 	AvailableAmount int                  `json:"availableAmount" db:"available_amount"`
@@ -15,8 +21,9 @@ type Sku struct {
 
 func (sku *Sku) save(tx *sqlx.Tx) error {
 	return tx.Get(&sku.ID, `
-	INSERT INTO skus (product_id, available_amount, full_price, purchase_price, created_at)
-	  VALUES ($1, $2, $3, $4, NOW()) RETURNING id`,
+	INSERT INTO skus (portal_id, product_id, available_amount, full_price, purchase_price, created_at)
+	  VALUES ($1, $2, $3, $4, $5, NOW()) RETURNING id`,
+		sku.PortalID,
 		sku.ProductID,
 		sku.AvailableAmount,
 		sku.FullPrice,
@@ -29,3 +36,64 @@ type SkuCharacteristic struct {
 	CharIndex  int `json:"charIndex"`
 	ValueIndex int `json:"valueIndex"`
 }
+
+// SkuSnapshot is price and stock of SKU observed in crawl session
+type SkuSnapshot struct {
+	PortalSkuID     int64     `json:"skuId" db:"portal_sku_id"`
+	ProductPortalID int64     `json:"productId" db:"product_portal_id"`
+	SessionID       int64     `json:"sessionId" db:"session_id"`
+	AvailableAmount int       `json:"availableAmount" db:"available_amount"`
+	FullPrice       float32   `json:"fullPrice" db:"full_price"`
+	PurchasePrice   float32   `json:"purchasePrice" db:"purchase_price"`
+	ObservedAt      time.Time `json:"observedAt" db:"observed_at"`
+}
+
+// saveSkuSnapshots records SKUs of the product observed in its session.
+// SKUs without portal ID have no stable identity and are skipped.
+func (p *Product) saveSkuSnapshots(ctx context.Context, tx *sqlx.Tx) error {
+	for _, s := range p.SkuList {
+		if s.PortalID == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO sku_snapshots
+		  (portal_sku_id, product_portal_id, session_id, available_amount, full_price, purchase_price, observed_at)
+		  VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		  ON CONFLICT ON CONSTRAINT uniq_portal_sku_id_session_id_sku_snapshots DO NOTHING`,
+			s.PortalID, p.PortalID, p.SessionID, s.AvailableAmount, s.FullPrice, s.PurchasePrice); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SkuHistory returns price and stock series of SKU observed between from and to
+func SkuHistory(ctx context.Context, db *sqlx.DB, portalSkuID int64, from time.Time, to time.Time) ([]*SkuSnapshot, error) {
+	history := []*SkuSnapshot{}
+	err := db.SelectContext(ctx, &history, `SELECT portal_sku_id, product_portal_id, session_id,
+	    available_amount, full_price, purchase_price, observed_at
+	  FROM sku_snapshots
+	  WHERE portal_sku_id = $1 AND observed_at BETWEEN $2 AND $3
+	  ORDER BY observed_at`, portalSkuID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// ProductSkuHistory returns price and stock series of all SKUs of the product
+// observed between from and to ordered by SKU and time
+func ProductSkuHistory(ctx context.Context, db *sqlx.DB, productPortalID int64, from time.Time, to time.Time) ([]*SkuSnapshot, error) {
+	history := []*SkuSnapshot{}
+	err := db.SelectContext(ctx, &history, `SELECT portal_sku_id, product_portal_id, session_id,
+	    available_amount, full_price, purchase_price, observed_at
+	  FROM sku_snapshots
+	  WHERE product_portal_id = $1 AND observed_at BETWEEN $2 AND $3
+	  ORDER BY portal_sku_id, observed_at`, productPortalID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
@@ -0,0 +1,303 @@
+// Package testserver is a fake KazanExpress API serving generated data.
+// It is used to run the crawler end to end without network.
+package testserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/isqad/kexpress/internal/logger"
+	"github.com/isqad/kexpress/internal/service"
+)
+
+// Options configures generated data and faults of the server
+type Options struct {
+	// Seed of the data generator, the same seed gives the same catalog
+	Seed int64
+	// Roots is number of root categories
+	Roots int
+	// Children is number of child categories of every non leaf category
+	Children int
+	// Depth is number of category levels below roots
+	Depth int
+	// Products is number of products in every leaf category
+	Products int
+	// Sellers is number of distinct sellers
+	Sellers int
+
+	// Latency is added to every response
+	Latency time.Duration
+	// ErrorRate is share of requests answered with 500
+	ErrorRate float64
+	// RateLimitRate is share of requests answered with 429
+	RateLimitRate float64
+	// NotFoundRate is share of product cards answered with "not found" error
+	NotFoundRate float64
+	// DuplicateRate is share of listing items replaced by a product of the previous page
+	DuplicateRate float64
+	// AmountSkew is added to products amount reported for categories,
+	// so crawler sees more or less pages than there are
+	AmountSkew int
+	// Tick is period of catalog changes: every tick products get new orders
+	// and lose stock. Zero means the catalog never changes.
+	Tick time.Duration
+}
+
+// DefaultOptions is small catalog without faults
+var DefaultOptions = Options{
+	Seed:     1,
+	Roots:    2,
+	Children: 3,
+	Depth:    2,
+	Products: 50,
+	Sellers:  10,
+}
+
+// Server is fake API
+type Server struct {
+	opts     Options
+	root     *service.Category
+	leaves   map[int64][]int64
+	products map[int64]int64
+	sellers  []*service.Seller
+	started  time.Time
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// New creates server with catalog generated from opts
+func New(opts Options) *Server {
+	s := &Server{
+		opts:     opts,
+		leaves:   make(map[int64][]int64),
+		products: make(map[int64]int64),
+		rnd:      rand.New(rand.NewSource(opts.Seed)),
+		started:  time.Now(),
+	}
+	s.generate()
+
+	return s
+}
+
+// Handler returns HTTP handler of the API
+func (s *Server) Handler() http.Handler {
+	r := chi.NewRouter()
+	r.Use(s.faults)
+	r.Get("/api/v2/main/search/category", s.categories)
+	r.Get("/api/v2/main/search/product", s.productList)
+	r.Get("/api/v2/product/{id}", s.product)
+
+	return r
+}
+
+func (s *Server) generate() {
+	for i := 0; i < s.opts.Sellers; i++ {
+		s.sellers = append(s.sellers, &service.Seller{
+			PortalID: int64(1000 + i),
+			Title:    fmt.Sprintf("Seller %d", i+1),
+			Orders:   s.rnd.Intn(100000),
+			Reviews:  s.rnd.Intn(10000),
+			Rating:   float32(30+s.rnd.Intn(21)) / 10,
+		})
+	}
+
+	nextID := int64(10000)
+	nextProductID := int64(100000)
+	var build func(title string, level int) *service.Category
+	build = func(title string, level int) *service.Category {
+		nextID++
+		c := &service.Category{PortalID: nextID, Title: title, Children: []*service.Category{}}
+		if level == s.opts.Depth {
+			for i := 0; i < s.opts.Products; i++ {
+				nextProductID++
+				s.leaves[c.PortalID] = append(s.leaves[c.PortalID], nextProductID)
+				s.products[nextProductID] = c.PortalID
+			}
+			c.ProductAmount = s.opts.Products + s.opts.AmountSkew
+			if c.ProductAmount < 0 {
+				c.ProductAmount = 0
+			}
+			return c
+		}
+
+		for i := 0; i < s.opts.Children; i++ {
+			child := build(fmt.Sprintf("%s.%d", title, i+1), level+1)
+			c.Children = append(c.Children, child)
+			c.ProductAmount += child.ProductAmount
+		}
+		return c
+	}
+
+	s.root = &service.Category{PortalID: 1, Title: "Все категории", Children: []*service.Category{}}
+	for i := 0; i < s.opts.Roots; i++ {
+		s.root.Children = append(s.root.Children, build(fmt.Sprintf("Category %d", i+1), 0))
+	}
+}
+
+// chance reports whether event with probability p happened
+func (s *Server) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rnd.Float64() < p
+}
+
+func (s *Server) faults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.opts.Latency > 0 {
+			time.Sleep(s.opts.Latency)
+		}
+		if s.chance(s.opts.RateLimitRate) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		if s.chance(s.opts.ErrorRate) {
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) categories(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, &service.CategoryResponse{
+		Payload: &service.CategoryResponsePayload{RootCategory: s.root},
+	})
+}
+
+func (s *Server) productList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	categoryID, _ := strconv.ParseInt(q.Get("categoryId"), 10, 64)
+	page, _ := strconv.Atoi(q.Get("page"))
+	size, err := strconv.Atoi(q.Get("size"))
+	if err != nil || size <= 0 {
+		size = 24
+	}
+
+	ids, ok := s.leaves[categoryID]
+	if !ok {
+		writeJSON(w, &service.ProductListResponse{Error: "category not found"})
+		return
+	}
+
+	payload := &service.ProductListReponsePayload{
+		TotalProducts: len(ids),
+		Products:      []*service.ProductOfList{},
+	}
+	for i := page * size; i < (page+1)*size && i < len(ids); i++ {
+		id := ids[i]
+		if page > 0 && s.chance(s.opts.DuplicateRate) {
+			id = ids[(page-1)*size+i%size]
+		}
+		p := s.card(id, categoryID)
+		payload.Products = append(payload.Products, &service.ProductOfList{
+			PortalID:         p.PortalID,
+			Title:            p.Title,
+			PortalCategoryID: categoryID,
+			Rating:           p.Rating,
+			SellPrice:        p.SkuList[0].PurchasePrice,
+		})
+	}
+
+	writeJSON(w, &service.ProductListResponse{Payload: payload})
+}
+
+func (s *Server) product(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSON(w, &service.ProductResponse{Error: "not found"})
+		return
+	}
+
+	categoryID, ok := s.products[id]
+	if !ok || s.chance(s.opts.NotFoundRate) {
+		writeJSON(w, &service.ProductResponse{Error: "not found"})
+		return
+	}
+
+	writeJSON(w, &service.ProductResponse{
+		Payload: service.ProductResponsePayload{Data: s.card(id, categoryID)},
+	})
+}
+
+// card generates product card, the same id always gives the same card
+func (s *Server) card(id int64, categoryID int64) *service.Product {
+	rnd := rand.New(rand.NewSource(s.opts.Seed ^ id))
+	ticks := 0
+	if s.opts.Tick > 0 {
+		ticks = int(time.Since(s.started) / s.opts.Tick)
+	}
+	velocity := rnd.Intn(10)
+
+	description := fmt.Sprintf("Description of product %d", id)
+	colors := []string{"Красный", "Синий", "Черный", "Белый"}
+	sizes := []string{"S", "M", "L", "XL"}
+	chars := []*service.Characteristic{
+		{Title: "Цвет", Values: []*service.CharValue{}},
+		{Title: "Размер", Values: []*service.CharValue{}},
+	}
+	for _, c := range colors[:1+rnd.Intn(len(colors))] {
+		chars[0].Values = append(chars[0].Values, &service.CharValue{Title: c, Value: c})
+	}
+	for _, sz := range sizes[:1+rnd.Intn(len(sizes))] {
+		chars[1].Values = append(chars[1].Values, &service.CharValue{Title: sz, Value: sz})
+	}
+
+	price := float32(100 + rnd.Intn(5000))
+	p := &service.Product{
+		PortalID:        id,
+		Title:           fmt.Sprintf("Product %d", id),
+		Description:     &description,
+		Category:        &service.Category{PortalID: categoryID},
+		OrdersAmount:    rnd.Intn(5000) + velocity*ticks,
+		ReviewsAmount:   rnd.Intn(500),
+		Rating:          float32(30+rnd.Intn(21)) / 10,
+		Characteristics: chars,
+		SkuList:         []*service.Sku{},
+	}
+	if len(s.sellers) > 0 {
+		p.Seller = s.sellers[rnd.Intn(len(s.sellers))]
+	} else {
+		p.Seller = &service.Seller{}
+	}
+
+	for ci := range chars[0].Values {
+		for si := range chars[1].Values {
+			sku := &service.Sku{
+				PortalID:        id*100 + int64(len(p.SkuList)),
+				AvailableAmount: rnd.Intn(100),
+				FullPrice:       price * 1.3,
+				PurchasePrice:   price,
+				Characteristics: []*service.SkuCharacteristic{
+					{CharIndex: 0, ValueIndex: ci},
+					{CharIndex: 1, ValueIndex: si},
+				},
+			}
+			if sku.AvailableAmount -= velocity * ticks; sku.AvailableAmount < 0 {
+				sku.AvailableAmount = 0
+			}
+			p.TotalAvailableAmount += sku.AvailableAmount
+			p.SkuList = append(p.SkuList, sku)
+		}
+	}
+
+	return p
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Default().With("error", err).Errorf("encode response failed")
+	}
+}
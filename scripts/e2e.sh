@@ -0,0 +1,60 @@
+#!/bin/bash
+
+# Crawls the fake API into the local database and checks resulting row counts,
+# exits with non-zero status on mismatch. Expects empty database with migrated schema.
+# Usage: PGPASSWORD=qwerty scripts/e2e.sh
+
+set -e
+
+api=http://localhost:3001
+roots=1
+children=2
+depth=2
+products=30
+
+go run ./cmd/fakeapi --addr :3001 --roots ${roots} --children ${children} --depth ${depth} --products ${products} &
+fake_pid=$!
+trap "kill ${fake_pid}" EXIT
+sleep 2
+
+psql="psql -h localhost -p 15432 -U postgres -d kexpress -tA"
+kexpress="go run ./cmd/service -P ${PGPASSWORD} --api-url ${api} --rps 0"
+
+${kexpress} crawl categories
+root=$(${psql} -c "SELECT id FROM categories WHERE parent_id = 0")
+${kexpress} crawl list --root ${root}
+${kexpress} crawl products --root ${root}
+
+level=${roots}
+want_categories=${roots}
+for ((i = 0; i < depth; i++)); do
+  level=$((level * children))
+  want_categories=$((want_categories + level))
+done
+want_products=$((level / roots * products))
+
+failed=0
+check() {
+  local name=$1 want=$2 query=$3
+  local got
+  got=$(${psql} -c "${query}")
+  if [ "${got}" != "${want}" ]; then
+    echo "FAIL ${name}: got ${got}, want ${want}"
+    failed=1
+  else
+    echo "ok   ${name}: ${got}"
+  fi
+}
+
+check categories ${want_categories} "SELECT count(*) FROM categories"
+check products ${want_products} "SELECT count(*) FROM products"
+check observations ${want_products} "SELECT count(*) FROM product_observations"
+check observed ${want_products} "SELECT count(*) FROM product_observations WHERE observed_at IS NOT NULL"
+check "products observed twice" 0 "SELECT count(*) FROM (
+  SELECT product_id FROM product_observations GROUP BY product_id, session_id HAVING count(*) > 1) d"
+check "products without skus" 0 "SELECT count(*) FROM products p WHERE NOT EXISTS (SELECT NULL FROM skus s WHERE s.product_id = p.id)"
+# fake API gives every SKU two characteristic values
+check "skus without two char values" 0 "SELECT count(*) FROM skus s
+  WHERE (SELECT count(*) FROM sku_char_values v WHERE v.sku_id = s.id) != 2"
+
+exit ${failed}
@@ -1,53 +1,105 @@
 import React, { useEffect, useState } from 'react';
 import { useParams } from "react-router-dom";
 
+const periods = [7, 30, 90];
+
+const columns = [
+  { key: 'productAmount', title: 'Кол-во товаров' },
+  { key: 'activeProducts', title: 'Активных товаров' },
+  { key: 'sellersCount', title: 'Продавцов' },
+  { key: 'sellsCount', title: 'Продажи, шт.' },
+  { key: 'avgPrice', title: 'Средняя цена' },
+  { key: 'proceeds', title: 'Выручка' },
+];
+
 export default function RubricStatistics() {
   const highLightRe = /(\/?\s?)([^/]+)$/;
   const [categories, setCategories] = useState(null);
   const [isLoading, setIsLoading] = useState(false);
-  const [loadedCategory, setLoadedCategory] = useState(null);
+  const [loadedQuery, setLoadedQuery] = useState(null);
+  const [period, setPeriod] = useState(30);
+  const [sort, setSort] = useState('-proceeds');
   let { id } = useParams();
 
+  const query = `root_id=${id}&period=${period}&sort=${sort}`;
+
   useEffect(() => {
-    if (isLoading || loadedCategory === id && categories) {
+    if (isLoading || loadedQuery === query && categories) {
       return;
     }
 
     setIsLoading(true);
 
-    fetch(`/api/v1/categories?root_id=${id}`, {
+    fetch(`/api/v1/categories?${query}`, {
       headers: {
         Accept: 'application/json',
         'Content-Type': 'application/json'
       }
     }).then(response => response.json())
       .then(categories => {
-        setLoadedCategory(id);
+        setLoadedQuery(query);
         setCategories(categories);
         setIsLoading(false);
       });
   });
 
+  const sortBy = key => {
+    setSort(sort === `-${key}` ? key : `-${key}`);
+  };
+
+  const sortMark = key => {
+    if (sort === key) {
+      return ' ▲';
+    }
+    if (sort === `-${key}`) {
+      return ' ▼';
+    }
+    return '';
+  };
+
+  const format = value => Math.round(value || 0).toLocaleString('ru-RU');
+
   return (
-    <table className="table">
-      <thead>
-        <tr>
-          <th>Рубрика</th>
-          <th>Кол-во товаров</th>
-          <th>Выручка</th>
-        </tr>
-      </thead>
-      <tbody>
-        {categories && categories.map(category => {
+    <div>
+      <div className="btn-group mb-3">
+        {periods.map(p => {
           return (
-            <tr key={category.projectId}>
-              <td dangerouslySetInnerHTML={{__html: category.title.replace(highLightRe, "$1<b>$2</b>")}}></td>
-              <td>{category.productAmount}</td>
-              <td>TODO</td>
-            </tr>
+            <button key={p} type="button"
+                    className={p === period ? 'btn btn-primary' : 'btn btn-outline-primary'}
+                    onClick={() => setPeriod(p)}>
+              {p} дней
+            </button>
           );
         })}
-      </tbody>
-    </table>
+      </div>
+      <table className="table">
+        <thead>
+          <tr>
+            <th onClick={() => sortBy('title')}>Рубрика{sortMark('title')}</th>
+            {columns.map(column => {
+              return (
+                <th key={column.key} onClick={() => sortBy(column.key)}>
+                  {column.title}{sortMark(column.key)}
+                </th>
+              );
+            })}
+          </tr>
+        </thead>
+        <tbody>
+          {categories && categories.map(category => {
+            return (
+              <tr key={category.projectId}>
+                <td dangerouslySetInnerHTML={{__html: category.title.replace(highLightRe, "$1<b>$2</b>")}}></td>
+                {columns.map(column => {
+                  return (
+                    <td key={column.key}>{format(category[column.key])}</td>
+                  );
+                })}
+              </tr>
+            );
+          })}
+        </tbody>
+      </table>
+    </div>
   );
 }